@@ -0,0 +1,300 @@
+// Package admin provides an embeddable REST API managing the permissions,
+// roles and users of an srbac.Rbac.
+//
+// Mount it under a prefix with http.StripPrefix:
+//
+//	mux.Handle("/admin/", http.StripPrefix("/admin", admin.NewHandler(rbac)))
+//
+// Routes, with {id} the numeric id of a row:
+//
+//	GET    /permissions            list, paginated with ?limit= and ?after=
+//	POST   /permissions            create
+//	GET    /permissions/{id}       get
+//	PUT    /permissions/{id}       replace
+//	DELETE /permissions/{id}       delete
+//	...                            the same for /roles and /users
+//	PUT    /users/{id}/roles       replace the roles of a user with {"roles": [...]}
+//	POST   /users/{id}/roles       add {"roles": [...]} to the roles of a user
+//	DELETE /users/{id}/roles/{rid} remove role rid from a user
+//	GET    /check?user_id=&permission_id=
+//	GET    /schemas/{permission|role|user}
+//
+// Errors are returned as {"error": "..."} with status 400 for invalid input,
+// 404 for unknown rows, and 409 for version conflicts, names already taken and
+// deletes of permissions granted by a role or roles held by a user.
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/yinloo-ola/srbac"
+	"github.com/yinloo-ola/srbac/models"
+	"github.com/yinloo-ola/srbac/store"
+)
+
+// Handler serves the admin API of an srbac.Rbac.
+type Handler struct {
+	rbac        *srbac.Rbac
+	permissions resource[models.Permission, *models.Permission]
+	roles       resource[models.Role, *models.Role]
+	users       resource[models.User, *models.User]
+}
+
+// NewHandler returns the admin API of rbac.
+func NewHandler(rbac *srbac.Rbac) *Handler {
+	h := &Handler{rbac: rbac}
+	h.permissions = resource[models.Permission, *models.Permission]{
+		store:  rbac.PermissionStore,
+		id:     func(o *models.Permission) *int64 { return &o.Id },
+		remove: rbac.DeletePermissions,
+		validate: func(o *models.Permission) error {
+			if o.Name == "" {
+				return invalidf("name is required")
+			}
+			return nil
+		},
+	}
+	h.roles = resource[models.Role, *models.Role]{
+		store:  rbac.RoleStore,
+		id:     func(o *models.Role) *int64 { return &o.Id },
+		remove: rbac.DeleteRoles,
+		guard:  rbac.WriteReferences,
+		validate: func(o *models.Role) error {
+			if o.Name == "" {
+				return invalidf("name is required")
+			}
+			return checkExist(rbac.PermissionStore, "permission", o.Permissions)
+		},
+	}
+	h.users = resource[models.User, *models.User]{
+		store: rbac.UserStore,
+		id:    func(o *models.User) *int64 { return &o.Id },
+		guard: rbac.WriteReferences,
+		validate: func(o *models.User) error {
+			if o.UserID == "" {
+				return invalidf("user_id is required")
+			}
+			return checkExist(rbac.RoleStore, "role", o.Roles)
+		},
+	}
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch parts[0] {
+	case "permissions":
+		h.permissions.serve(w, r, parts[1:])
+	case "roles":
+		h.roles.serve(w, r, parts[1:])
+	case "users":
+		if len(parts) >= 3 && parts[2] == "roles" {
+			h.serveUserRoles(w, r, parts[1], parts[3:])
+			return
+		}
+		h.users.serve(w, r, parts[1:])
+	case "check":
+		if len(parts) != 1 {
+			writeError(w, errNotFound)
+			return
+		}
+		h.serveCheck(w, r)
+	case "schemas":
+		serveSchema(w, r, parts[1:])
+	default:
+		writeError(w, errNotFound)
+	}
+}
+
+// serveUserRoles assigns roles to the user with id.
+func (h *Handler) serveUserRoles(w http.ResponseWriter, r *http.Request, id string, rest []string) {
+	userID, err := parseID(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var update func(roles []int64) []int64
+	switch {
+	case len(rest) == 0 && (r.Method == http.MethodPut || r.Method == http.MethodPost):
+		var body struct {
+			Roles []int64 `json:"roles"`
+		}
+		err = decode(r, &body)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		err = checkExist(h.rbac.RoleStore, "role", body.Roles)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		replace := r.Method == http.MethodPut
+		update = func(roles []int64) []int64 {
+			if replace {
+				roles = []int64{}
+			}
+			return appendMissing(roles, body.Roles...)
+		}
+	case len(rest) == 1 && r.Method == http.MethodDelete:
+		roleID, err := parseID(rest[0])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		update = func(roles []int64) []int64 {
+			kept := make([]int64, 0, len(roles))
+			for _, id := range roles {
+				if id != roleID {
+					kept = append(kept, id)
+				}
+			}
+			return kept
+		}
+	case len(rest) > 1:
+		writeError(w, errNotFound)
+		return
+	default:
+		writeError(w, errMethodNotAllowed)
+		return
+	}
+
+	user, err := h.rbac.UpdateUserRoles(userID, update)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+type checkResponse struct {
+	UserID       string `json:"user_id"`
+	PermissionID int64  `json:"permission_id"`
+	Allowed      bool   `json:"allowed"`
+}
+
+// serveCheck reports whether a user has a permission.
+func (h *Handler) serveCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, errMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	userID := query.Get("user_id")
+	if userID == "" {
+		writeError(w, invalidf("user_id is required"))
+		return
+	}
+	permissionID, err := parseID(query.Get("permission_id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	allowed, err := h.rbac.HasPermission(userID, permissionID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, checkResponse{UserID: userID, PermissionID: permissionID, Allowed: allowed})
+}
+
+// checkExist returns an invalid input error unless all ids are in s.
+func checkExist[T any, R store.Row[T]](s store.Store[T, R], kind string, ids []int64) error {
+	unique := appendMissing(nil, ids...)
+	if len(unique) == 0 {
+		return nil
+	}
+	found, err := s.GetMulti(unique)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	if len(found) != len(unique) {
+		return invalidf("unknown %s in %v", kind, ids)
+	}
+	return nil
+}
+
+// appendMissing appends the ids not in s yet to s.
+func appendMissing(s []int64, ids ...int64) []int64 {
+	for _, id := range ids {
+		found := false
+		for _, v := range s {
+			if v == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			s = append(s, id)
+		}
+	}
+	return s
+}
+
+// httpError is an error with the status it is returned with.
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e *httpError) Error() string {
+	return e.msg
+}
+
+var (
+	errNotFound         = &httpError{http.StatusNotFound, "not found"}
+	errMethodNotAllowed = &httpError{http.StatusMethodNotAllowed, "method not allowed"}
+)
+
+func invalidf(format string, args ...any) error {
+	return &httpError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+func parseID(s string) (int64, error) {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, invalidf("invalid id %q", s)
+	}
+	return id, nil
+}
+
+func decode(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err != nil {
+		return invalidf("invalid body: %v", err)
+	}
+	return nil
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var herr *httpError
+	switch {
+	case errors.As(err, &herr):
+		status = herr.status
+	case errors.Is(err, store.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, srbac.ErrRoleMissing):
+		status = http.StatusBadRequest
+	case errors.Is(err, store.ErrConflict), errors.Is(err, store.ErrDuplicate), errors.Is(err, srbac.ErrInUse):
+		status = http.StatusConflict
+	}
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,190 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yinloo-ola/srbac"
+	"github.com/yinloo-ola/srbac/helper"
+	"github.com/yinloo-ola/srbac/models"
+	sqlitestore "github.com/yinloo-ola/srbac/store/sqlite-store"
+)
+
+func newTestHandler(t *testing.T) http.Handler {
+	db, err := sqlitestore.Open(t.Name(), sqlitestore.WithInMemory())
+	helper.PanicErr(err)
+	t.Cleanup(func() { _ = db.Close() })
+	permissionStore, err := sqlitestore.NewStoreFromDB[models.Permission](db)
+	helper.PanicErr(err)
+	roleStore, err := sqlitestore.NewStoreFromDB[models.Role](db)
+	helper.PanicErr(err)
+	userStore, err := sqlitestore.NewStoreFromDB[models.User](db)
+	helper.PanicErr(err)
+	return http.StripPrefix("/admin", NewHandler(srbac.NewRbac(permissionStore, roleStore, userStore)))
+}
+
+// do serves a request with the JSON encoding of body and decodes the response into out.
+func do(t *testing.T, h http.Handler, method, path string, body, out any) int {
+	var buf bytes.Buffer
+	if body != nil {
+		assert.NoError(t, json.NewEncoder(&buf).Encode(body))
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, path, &buf))
+	if out != nil {
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), out), w.Body.String())
+	}
+	return w.Code
+}
+
+func TestCRUD(t *testing.T) {
+	h := newTestHandler(t)
+
+	var perm models.Permission
+	code := do(t, h, http.MethodPost, "/admin/permissions", map[string]any{"name": "read", "description": "read things"}, &perm)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, int64(1), perm.Id)
+	assert.Equal(t, "read", perm.Name)
+	assert.False(t, perm.CreatedAt.IsZero())
+
+	var errResp errorResponse
+	assert.Equal(t, http.StatusBadRequest, do(t, h, http.MethodPost, "/admin/permissions", map[string]any{"description": "no name"}, &errResp))
+	assert.Equal(t, "name is required", errResp.Error)
+	assert.Equal(t, http.StatusBadRequest, do(t, h, http.MethodPost, "/admin/permissions", map[string]any{"nme": "typo"}, nil))
+	assert.Equal(t, http.StatusConflict, do(t, h, http.MethodPost, "/admin/permissions", map[string]any{"name": "read"}, nil))
+
+	perm.Description = "read all things"
+	assert.Equal(t, http.StatusOK, do(t, h, http.MethodPut, "/admin/permissions/1", perm, &perm))
+	assert.Equal(t, "read all things", perm.Description)
+
+	var role models.Role
+	code = do(t, h, http.MethodPost, "/admin/roles", map[string]any{"name": "reader", "permissions": []int64{1}}, &role)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, int64(1), role.Version)
+	assert.Equal(t, http.StatusBadRequest, do(t, h, http.MethodPost, "/admin/roles", map[string]any{"name": "bad", "permissions": []int64{9}}, nil))
+
+	role.Description = "reads"
+	assert.Equal(t, http.StatusOK, do(t, h, http.MethodPut, "/admin/roles/1", role, nil))
+	// role still holds the old version
+	assert.Equal(t, http.StatusConflict, do(t, h, http.MethodPut, "/admin/roles/1", role, nil))
+
+	var user models.User
+	code = do(t, h, http.MethodPost, "/admin/users", map[string]any{"user_id": "alice", "email": "alice@example.com"}, &user)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, "alice@example.com", *user.Email)
+
+	assert.Equal(t, http.StatusOK, do(t, h, http.MethodGet, "/admin/users/1", nil, &user))
+	assert.Equal(t, "alice", user.UserID)
+	assert.Equal(t, http.StatusNotFound, do(t, h, http.MethodGet, "/admin/users/7", nil, &errResp))
+	assert.Equal(t, http.StatusBadRequest, do(t, h, http.MethodGet, "/admin/users/abc", nil, nil))
+	assert.Equal(t, http.StatusNotFound, do(t, h, http.MethodGet, "/admin/groups", nil, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, do(t, h, http.MethodPatch, "/admin/users/1", nil, nil))
+
+	// role 1 grants permission 1 and alice holds role 1
+	assert.Equal(t, http.StatusOK, do(t, h, http.MethodPost, "/admin/users/1/roles", map[string]any{"roles": []int64{1}}, nil))
+	assert.Equal(t, http.StatusConflict, do(t, h, http.MethodDelete, "/admin/permissions/1", nil, &errResp))
+	assert.Equal(t, `record in use: permission 1 is granted by role "reader"`, errResp.Error)
+	assert.Equal(t, http.StatusConflict, do(t, h, http.MethodDelete, "/admin/roles/1", nil, nil))
+	assert.Equal(t, http.StatusOK, do(t, h, http.MethodDelete, "/admin/users/1/roles/1", nil, nil))
+	assert.Equal(t, http.StatusNoContent, do(t, h, http.MethodDelete, "/admin/roles/1", nil, nil))
+
+	assert.Equal(t, http.StatusNoContent, do(t, h, http.MethodDelete, "/admin/permissions/1", nil, nil))
+	assert.Equal(t, http.StatusNotFound, do(t, h, http.MethodDelete, "/admin/permissions/1", nil, nil))
+	assert.Equal(t, http.StatusNotFound, do(t, h, http.MethodGet, "/admin/permissions/1", nil, nil))
+	// the name of a deleted permission can be used again
+	code = do(t, h, http.MethodPost, "/admin/permissions", map[string]any{"name": "read"}, &perm)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.NotEqual(t, int64(1), perm.Id)
+}
+
+func TestPagination(t *testing.T) {
+	h := newTestHandler(t)
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusCreated, do(t, h, http.MethodPost, "/admin/permissions", map[string]any{"name": fmt.Sprintf("perm %d", i)}, nil))
+	}
+	assert.Equal(t, http.StatusNoContent, do(t, h, http.MethodDelete, "/admin/permissions/2", nil, nil))
+
+	var p page[models.Permission]
+	assert.Equal(t, http.StatusOK, do(t, h, http.MethodGet, "/admin/permissions?limit=2", nil, &p))
+	assert.Equal(t, []int64{1, 3}, permissionIDs(p.Items))
+	assert.Equal(t, int64(3), p.NextAfter)
+
+	p = page[models.Permission]{}
+	assert.Equal(t, http.StatusOK, do(t, h, http.MethodGet, "/admin/permissions?limit=2&after=3", nil, &p))
+	assert.Equal(t, []int64{4, 5}, permissionIDs(p.Items))
+	assert.Equal(t, int64(0), p.NextAfter)
+
+	p = page[models.Permission]{}
+	assert.Equal(t, http.StatusOK, do(t, h, http.MethodGet, "/admin/permissions", nil, &p))
+	assert.Len(t, p.Items, 4)
+
+	assert.Equal(t, http.StatusBadRequest, do(t, h, http.MethodGet, "/admin/permissions?limit=0", nil, nil))
+	assert.Equal(t, http.StatusBadRequest, do(t, h, http.MethodGet, "/admin/permissions?after=x", nil, nil))
+}
+
+func permissionIDs(perms []models.Permission) []int64 {
+	ids := make([]int64, 0, len(perms))
+	for _, p := range perms {
+		ids = append(ids, p.Id)
+	}
+	return ids
+}
+
+func TestRolesAndCheck(t *testing.T) {
+	h := newTestHandler(t)
+	do(t, h, http.MethodPost, "/admin/permissions", map[string]any{"name": "read"}, nil)
+	do(t, h, http.MethodPost, "/admin/permissions", map[string]any{"name": "write"}, nil)
+	do(t, h, http.MethodPost, "/admin/roles", map[string]any{"name": "reader", "permissions": []int64{1}}, nil)
+	do(t, h, http.MethodPost, "/admin/roles", map[string]any{"name": "writer", "permissions": []int64{2}}, nil)
+	do(t, h, http.MethodPost, "/admin/users", map[string]any{"user_id": "alice"}, nil)
+
+	var check checkResponse
+	assert.Equal(t, http.StatusOK, do(t, h, http.MethodGet, "/admin/check?user_id=alice&permission_id=1", nil, &check))
+	assert.False(t, check.Allowed)
+
+	var user models.User
+	assert.Equal(t, http.StatusOK, do(t, h, http.MethodPost, "/admin/users/1/roles", map[string]any{"roles": []int64{1, 2}}, &user))
+	assert.Equal(t, []int64{1, 2}, user.Roles)
+	assert.Equal(t, http.StatusOK, do(t, h, http.MethodPost, "/admin/users/1/roles", map[string]any{"roles": []int64{2}}, &user))
+	assert.Equal(t, []int64{1, 2}, user.Roles)
+	assert.Equal(t, http.StatusBadRequest, do(t, h, http.MethodPost, "/admin/users/1/roles", map[string]any{"roles": []int64{3}}, nil))
+
+	assert.Equal(t, http.StatusOK, do(t, h, http.MethodGet, "/admin/check?user_id=alice&permission_id=1", nil, &check))
+	assert.True(t, check.Allowed)
+
+	assert.Equal(t, http.StatusOK, do(t, h, http.MethodDelete, "/admin/users/1/roles/1", nil, &user))
+	assert.Equal(t, []int64{2}, user.Roles)
+	assert.Equal(t, http.StatusOK, do(t, h, http.MethodGet, "/admin/check?user_id=alice&permission_id=1", nil, &check))
+	assert.False(t, check.Allowed)
+
+	assert.Equal(t, http.StatusOK, do(t, h, http.MethodPut, "/admin/users/1/roles", map[string]any{"roles": []int64{1}}, &user))
+	assert.Equal(t, []int64{1}, user.Roles)
+
+	assert.Equal(t, http.StatusNotFound, do(t, h, http.MethodGet, "/admin/check?user_id=bob&permission_id=1", nil, nil))
+	assert.Equal(t, http.StatusBadRequest, do(t, h, http.MethodGet, "/admin/check?user_id=alice", nil, nil))
+	assert.Equal(t, http.StatusNotFound, do(t, h, http.MethodPost, "/admin/users/9/roles", map[string]any{"roles": []int64{1}}, nil))
+}
+
+func TestSchemas(t *testing.T) {
+	h := newTestHandler(t)
+
+	var schema map[string]any
+	assert.Equal(t, http.StatusOK, do(t, h, http.MethodGet, "/admin/schemas/user", nil, &schema))
+	assert.Equal(t, "User", schema["title"])
+	assert.Equal(t, []any{"user_id"}, schema["required"])
+	props := schema["properties"].(map[string]any)
+	assert.Equal(t, map[string]any{"type": "array", "items": map[string]any{"type": "integer"}}, props["roles"])
+	assert.Equal(t, map[string]any{"type": []any{"string", "null"}}, props["email"])
+	assert.Equal(t, map[string]any{"type": "integer", "readOnly": true}, props["id"])
+	assert.NotContains(t, props, "deleted_at")
+
+	var all map[string]any
+	assert.Equal(t, http.StatusOK, do(t, h, http.MethodGet, "/admin/schemas", nil, &all))
+	assert.Len(t, all, 3)
+	assert.Equal(t, http.StatusNotFound, do(t, h, http.MethodGet, "/admin/schemas/group", nil, nil))
+}
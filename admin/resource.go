@@ -0,0 +1,188 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/yinloo-ola/srbac/store"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// resource serves the CRUD routes of the rows of a store.
+type resource[T any, R store.Row[T]] struct {
+	store store.Store[T, R]
+	// id returns the id field of obj.
+	id       func(obj *T) *int64
+	validate func(obj *T) error
+	// remove, if set, deletes rows instead of store.DeleteMulti.
+	remove func(ids []int64) error
+	// guard, if set, runs the validation and write of creates and updates,
+	// such as Rbac.WriteReferences for rows referencing other rows.
+	guard func(fn func() error) error
+}
+
+// page is a page of a list. NextAfter is the after parameter of the next page,
+// zero on the last page.
+type page[T any] struct {
+	Items     []T   `json:"items"`
+	NextAfter int64 `json:"next_after,omitempty"`
+}
+
+func (o resource[T, R]) serve(w http.ResponseWriter, r *http.Request, rest []string) {
+	if len(rest) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			o.list(w, r)
+		case http.MethodPost:
+			o.create(w, r)
+		default:
+			writeError(w, errMethodNotAllowed)
+		}
+		return
+	}
+	if len(rest) > 1 {
+		writeError(w, errNotFound)
+		return
+	}
+
+	id, err := parseID(rest[0])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		o.get(w, id)
+	case http.MethodPut:
+		o.update(w, r, id)
+	case http.MethodDelete:
+		o.delete(w, id)
+	default:
+		writeError(w, errMethodNotAllowed)
+	}
+}
+
+// list returns the rows in id order, limit rows after the id after at a time.
+func (o resource[T, R]) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := defaultPageSize
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxPageSize {
+			writeError(w, invalidf("limit must be between 1 and %d", maxPageSize))
+			return
+		}
+		limit = n
+	}
+	var after int64
+	if s := query.Get("after"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			writeError(w, invalidf("invalid after %q", s))
+			return
+		}
+		after = n
+	}
+
+	p := page[T]{Items: make([]T, 0, limit)}
+	var lastID int64
+	err := o.store.Iterate(func(obj T) error {
+		if len(p.Items) == limit {
+			p.NextAfter = lastID
+			return store.ErrStopIteration
+		}
+		p.Items = append(p.Items, obj)
+		lastID = *o.id(&obj)
+		return nil
+	}, &store.WhereCond{Field: "id", Op: store.OpGt, Val: after})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+func (o resource[T, R]) get(w http.ResponseWriter, id int64) {
+	obj, err := o.store.GetOne(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, obj)
+}
+
+func (o resource[T, R]) create(w http.ResponseWriter, r *http.Request) {
+	var obj T
+	err := decode(r, &obj)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	*o.id(&obj) = 0
+	var id int64
+	err = o.write(func() error {
+		err := o.validate(&obj)
+		if err != nil {
+			return err
+		}
+		id, err = o.store.Insert(obj)
+		return err
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	obj, err = o.store.GetOne(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, obj)
+}
+
+func (o resource[T, R]) update(w http.ResponseWriter, r *http.Request, id int64) {
+	var obj T
+	err := decode(r, &obj)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	*o.id(&obj) = id
+	err = o.write(func() error {
+		err := o.validate(&obj)
+		if err != nil {
+			return err
+		}
+		return o.store.Update(id, obj)
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	o.get(w, id)
+}
+
+func (o resource[T, R]) delete(w http.ResponseWriter, id int64) {
+	remove := o.store.DeleteMulti
+	if o.remove != nil {
+		remove = o.remove
+	}
+	err := remove([]int64{id})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// write runs fn under guard, if set.
+func (o resource[T, R]) write(fn func() error) error {
+	if o.guard == nil {
+		return fn()
+	}
+	return o.guard(fn)
+}
@@ -0,0 +1,90 @@
+package admin
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/yinloo-ola/srbac/models"
+)
+
+// schemas are the JSON schemas of the request and response bodies of each resource.
+var schemas = map[string]map[string]any{
+	"permission": schemaOf(models.Permission{}, "name"),
+	"role":       schemaOf(models.Role{}, "name"),
+	"user":       schemaOf(models.User{}, "user_id"),
+}
+
+// readOnly are the properties set by the store and ignored in requests.
+var readOnly = map[string]bool{"id": true, "created_at": true, "updated_at": true}
+
+func serveSchema(w http.ResponseWriter, r *http.Request, rest []string) {
+	if r.Method != http.MethodGet {
+		writeError(w, errMethodNotAllowed)
+		return
+	}
+	if len(rest) == 0 || rest[0] == "" {
+		writeJSON(w, http.StatusOK, schemas)
+		return
+	}
+	schema, ok := schemas[rest[0]]
+	if !ok || len(rest) > 1 {
+		writeError(w, errNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, schema)
+}
+
+// schemaOf returns the JSON schema of the JSON encoding of the struct v.
+func schemaOf(v any, required ...string) map[string]any {
+	typ := reflect.TypeOf(v)
+	props := map[string]any{}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		prop := typeSchema(field.Type)
+		if readOnly[name] {
+			prop["readOnly"] = true
+		}
+		props[name] = prop
+	}
+	return map[string]any{
+		"$schema":    "https://json-schema.org/draft/2020-12/schema",
+		"title":      typ.Name(),
+		"type":       "object",
+		"properties": props,
+		"required":   required,
+	}
+}
+
+func typeSchema(typ reflect.Type) map[string]any {
+	if typ == reflect.TypeOf(time.Time{}) {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch typ.Kind() {
+	case reflect.Pointer:
+		schema := typeSchema(typ.Elem())
+		schema["type"] = []any{schema["type"], "null"}
+		return schema
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(typ.Elem())}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	default:
+		return map[string]any{"type": "object"}
+	}
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/yinloo-ola/srbac"
+	"github.com/yinloo-ola/srbac/models"
+	"github.com/yinloo-ola/srbac/store"
+	sqlitestore "github.com/yinloo-ola/srbac/store/sqlite-store"
+)
+
+// ctl runs the commands against the stores of one database.
+type ctl struct {
+	db     *sqlitestore.DB
+	rbac   *srbac.Rbac
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	json   bool
+}
+
+func openCtl(path string, stdout io.Writer, jsonOutput bool) (*ctl, error) {
+	db, err := sqlitestore.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	permissionStore, err := sqlitestore.NewStoreFromDB[models.Permission](db)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	roleStore, err := sqlitestore.NewStoreFromDB[models.Role](db)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	userStore, err := sqlitestore.NewStoreFromDB[models.User](db)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &ctl{
+		db:     db,
+		rbac:   srbac.NewRbac(permissionStore, roleStore, userStore),
+		stdout: stdout,
+		json:   jsonOutput,
+	}, nil
+}
+
+func (c *ctl) close() {
+	_ = c.rbac.Close()
+	_ = c.db.Close()
+}
+
+// print writes v as JSON, or header and the rows of v as a table.
+func (c *ctl) print(v any, header []string, rows [][]string) error {
+	if c.json {
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	tw := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+func (c *ctl) listPermissions() error {
+	perms, err := c.rbac.PermissionStore.FindWhere()
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, 0, len(perms))
+	for _, p := range perms {
+		rows = append(rows, []string{strconv.FormatInt(p.Id, 10), p.Name, p.Description})
+	}
+	return c.print(perms, []string{"ID", "NAME", "DESCRIPTION"}, rows)
+}
+
+func (c *ctl) createPermission(args []string) error {
+	fs := flag.NewFlagSet("permissions create", flag.ContinueOnError)
+	description := fs.String("description", "", "description of the permission")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("permissions create: expects a name")
+	}
+	id, err := c.rbac.PermissionStore.Insert(models.Permission{Name: fs.Arg(0), Description: *description})
+	if err != nil {
+		return err
+	}
+	perm, err := c.rbac.PermissionStore.GetOne(id)
+	if err != nil {
+		return err
+	}
+	return c.print(perm, []string{"ID", "NAME", "DESCRIPTION"}, [][]string{{strconv.FormatInt(perm.Id, 10), perm.Name, perm.Description}})
+}
+
+func (c *ctl) deletePermissions(args []string) error {
+	if len(args) == 0 {
+		return errors.New("permissions delete: expects permissions")
+	}
+	ids, err := c.permissionIDs(args)
+	if err != nil {
+		return err
+	}
+	return c.rbac.DeletePermissions(ids)
+}
+
+func (c *ctl) listRoles() error {
+	roles, err := c.rbac.RoleStore.FindWhere()
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, 0, len(roles))
+	for _, r := range roles {
+		rows = append(rows, []string{strconv.FormatInt(r.Id, 10), r.Name, joinIDs(r.Permissions), r.Description})
+	}
+	return c.print(roles, []string{"ID", "NAME", "PERMISSIONS", "DESCRIPTION"}, rows)
+}
+
+func (c *ctl) createRole(args []string) error {
+	fs := flag.NewFlagSet("roles create", flag.ContinueOnError)
+	description := fs.String("description", "", "description of the role")
+	permissions := fs.String("permissions", "", "comma separated permissions granted by the role")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("roles create: expects a name")
+	}
+	var id int64
+	err = c.rbac.WriteReferences(func() error {
+		permIDs := []int64{}
+		if *permissions != "" {
+			permIDs, err = c.permissionIDs(strings.Split(*permissions, ","))
+			if err != nil {
+				return err
+			}
+		}
+		id, err = c.rbac.RoleStore.Insert(models.Role{Name: fs.Arg(0), Description: *description, Permissions: permIDs})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	role, err := c.rbac.RoleStore.GetOne(id)
+	if err != nil {
+		return err
+	}
+	return c.print(role, []string{"ID", "NAME", "PERMISSIONS", "DESCRIPTION"},
+		[][]string{{strconv.FormatInt(role.Id, 10), role.Name, joinIDs(role.Permissions), role.Description}})
+}
+
+func (c *ctl) deleteRoles(args []string) error {
+	if len(args) == 0 {
+		return errors.New("roles delete: expects roles")
+	}
+	ids, err := c.roleIDs(args)
+	if err != nil {
+		return err
+	}
+	return c.rbac.DeleteRoles(ids)
+}
+
+func (c *ctl) listUsers() error {
+	users, err := c.rbac.UserStore.FindWhere()
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, 0, len(users))
+	for _, u := range users {
+		rows = append(rows, userRow(u))
+	}
+	return c.print(users, []string{"ID", "USER", "ROLES"}, rows)
+}
+
+func userRow(u models.User) []string {
+	return []string{strconv.FormatInt(u.Id, 10), u.UserID, joinIDs(u.Roles)}
+}
+
+func (c *ctl) assign(args []string) error {
+	if len(args) < 2 {
+		return errors.New("assign: expects a user and roles")
+	}
+	roleIDs, err := c.roleIDs(args[1:])
+	if err != nil {
+		return err
+	}
+	user, err := c.findUser(args[0])
+	if errors.Is(err, store.ErrNotFound) {
+		user = models.User{UserID: args[0], Roles: []int64{}}
+		user.Id, err = c.rbac.UserStore.Insert(user)
+	}
+	if err != nil {
+		return err
+	}
+	return c.updateRoles(user.Id, func(roles []int64) []int64 {
+		for _, id := range roleIDs {
+			if !contains(roles, id) {
+				roles = append(roles, id)
+			}
+		}
+		return roles
+	})
+}
+
+func (c *ctl) revoke(args []string) error {
+	if len(args) < 2 {
+		return errors.New("revoke: expects a user and roles")
+	}
+	roleIDs, err := c.roleIDs(args[1:])
+	if err != nil {
+		return err
+	}
+	user, err := c.findUser(args[0])
+	if err != nil {
+		return err
+	}
+	return c.updateRoles(user.Id, func(roles []int64) []int64 {
+		kept := make([]int64, 0, len(roles))
+		for _, id := range roles {
+			if !contains(roleIDs, id) {
+				kept = append(kept, id)
+			}
+		}
+		return kept
+	})
+}
+
+func (c *ctl) updateRoles(id int64, update func(roles []int64) []int64) error {
+	user, err := c.rbac.UpdateUserRoles(id, update)
+	if err != nil {
+		return err
+	}
+	return c.print(user, []string{"ID", "USER", "ROLES"}, [][]string{userRow(user)})
+}
+
+type checkResult struct {
+	User       string `json:"user"`
+	Permission string `json:"permission"`
+	Allowed    bool   `json:"allowed"`
+}
+
+func (c *ctl) check(args []string) error {
+	if len(args) != 2 {
+		return errors.New("check: expects a user and a permission")
+	}
+	perm, err := c.findPermission(args[1])
+	if err != nil {
+		return err
+	}
+	allowed, err := c.rbac.HasPermission(args[0], perm.Id)
+	if err != nil {
+		return fmt.Errorf("user %q: %w", args[0], err)
+	}
+	result := "denied"
+	if allowed {
+		result = "allowed"
+	}
+	err = c.print(checkResult{User: args[0], Permission: perm.Name, Allowed: allowed},
+		[]string{"USER", "PERMISSION", "RESULT"}, [][]string{{args[0], perm.Name, result}})
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return errDenied
+	}
+	return nil
+}
+
+type explainRole struct {
+	Id     int64  `json:"id"`
+	Name   string `json:"name"`
+	Grants bool   `json:"grants"`
+}
+
+type explainResult struct {
+	User       string        `json:"user"`
+	Permission string        `json:"permission"`
+	Allowed    bool          `json:"allowed"`
+	Roles      []explainRole `json:"roles"`
+}
+
+func (c *ctl) explain(args []string) error {
+	if len(args) != 2 {
+		return errors.New("explain: expects a user and a permission")
+	}
+	perm, err := c.findPermission(args[1])
+	if err != nil {
+		return err
+	}
+	user, err := c.findUser(args[0])
+	if err != nil {
+		return err
+	}
+
+	result := explainResult{User: user.UserID, Permission: perm.Name, Roles: []explainRole{}}
+	roles, err := c.rbac.RoleStore.GetMulti(user.Roles)
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, 0, len(user.Roles))
+	for _, id := range user.Roles {
+		role, ok := findByID(roles, id)
+		if !ok {
+			rows = append(rows, []string{strconv.FormatInt(id, 10), "(missing)", "no"})
+			continue
+		}
+		grants := contains(role.Permissions, perm.Id)
+		result.Allowed = result.Allowed || grants
+		result.Roles = append(result.Roles, explainRole{Id: role.Id, Name: role.Name, Grants: grants})
+		rows = append(rows, []string{strconv.FormatInt(role.Id, 10), role.Name, yesNo(grants)})
+	}
+	if c.json {
+		return c.print(result, nil, nil)
+	}
+	verdict := "denied"
+	if result.Allowed {
+		verdict = "granted"
+	}
+	fmt.Fprintf(c.stdout, "%s is %s %s\n", user.UserID, verdict, perm.Name)
+	return c.print(result, []string{"ROLE ID", "ROLE", "GRANTS"}, rows)
+}
+
+// findPermission returns the permission with the id or name ref.
+func (c *ctl) findPermission(ref string) (models.Permission, error) {
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		perm, err := c.rbac.PermissionStore.GetOne(id)
+		if err != nil {
+			return perm, fmt.Errorf("permission %s: %w", ref, err)
+		}
+		return perm, nil
+	}
+	perms, err := c.rbac.PermissionStore.FindWhere(equal("name", ref))
+	if err != nil {
+		return models.Permission{}, err
+	}
+	if len(perms) != 1 {
+		return models.Permission{}, fmt.Errorf("permission %q: %d matches", ref, len(perms))
+	}
+	return perms[0], nil
+}
+
+func (c *ctl) permissionIDs(refs []string) ([]int64, error) {
+	ids := make([]int64, 0, len(refs))
+	for _, ref := range refs {
+		perm, err := c.findPermission(strings.TrimSpace(ref))
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, perm.Id)
+	}
+	return ids, nil
+}
+
+// findRole returns the role with the id or name ref.
+func (c *ctl) findRole(ref string) (models.Role, error) {
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		role, err := c.rbac.RoleStore.GetOne(id)
+		if err != nil {
+			return role, fmt.Errorf("role %s: %w", ref, err)
+		}
+		return role, nil
+	}
+	roles, err := c.rbac.RoleStore.FindWhere(equal("name", ref))
+	if err != nil {
+		return models.Role{}, err
+	}
+	if len(roles) != 1 {
+		return models.Role{}, fmt.Errorf("role %q: %d matches", ref, len(roles))
+	}
+	return roles[0], nil
+}
+
+func (c *ctl) roleIDs(refs []string) ([]int64, error) {
+	ids := make([]int64, 0, len(refs))
+	for _, ref := range refs {
+		role, err := c.findRole(ref)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, role.Id)
+	}
+	return ids, nil
+}
+
+// findUser returns the user with userID, or an error matching
+// srbac.ErrUserNotFound or srbac.ErrAmbiguousUser.
+func (c *ctl) findUser(userID string) (models.User, error) {
+	users, err := c.rbac.UserStore.FindWhere(equal("user_id", userID))
+	if err != nil {
+		return models.User{}, err
+	}
+	switch len(users) {
+	case 0:
+		return models.User{}, fmt.Errorf("%w: %q", srbac.ErrUserNotFound, userID)
+	case 1:
+		return users[0], nil
+	default:
+		return models.User{}, fmt.Errorf("%w: %d users %q", srbac.ErrAmbiguousUser, len(users), userID)
+	}
+}
+
+func findByID(roles []models.Role, id int64) (models.Role, bool) {
+	for _, r := range roles {
+		if r.Id == id {
+			return r, true
+		}
+	}
+	return models.Role{}, false
+}
+
+func contains(ids []int64, id int64) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func joinIDs(ids []int64) string {
+	s := make([]string, 0, len(ids))
+	for _, id := range ids {
+		s = append(s, strconv.FormatInt(id, 10))
+	}
+	return strings.Join(s, ",")
+}
+
+func joinDetails(details []string) string {
+	return strings.Join(details, "; ")
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,131 @@
+// Command srbacctl inspects and edits the srbac data in a SQLite file.
+//
+// Usage:
+//
+//	srbacctl [-db file] [-create] [-o table|json] <command> [args]
+//
+// Commands:
+//
+//	permissions list
+//	permissions create [-description text] <name>
+//	permissions delete <permission>...
+//	roles list
+//	roles create [-description text] [-permissions p1,p2] <name>
+//	roles delete <role>...
+//	users list
+//	assign <user> <role>...     adds roles to a user, creating the user if needed
+//	revoke <user> <role>...     removes roles from a user
+//	check <user> <permission>   exits with status 1 when the permission is denied
+//	explain <user> <permission> shows which roles of the user grant the permission
+//	export [-f file] [-format f]
+//	                            writes all data as a policy document
+//	import [-f file] [-format f] [-prune] [-dry-run]
+//	                            reconciles the data with a policy document
+//
+// The database file must exist unless -create is given. Permissions and roles
+// are given by id or name, users by user id. Policy documents are YAML, or JSON
+// with -o json or a .json file; see package policy. Errors exit with status 2.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// errDenied is returned by check when the permission is denied.
+var errDenied = errors.New("denied")
+
+func main() {
+	err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	if errors.Is(err, errDenied) {
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "srbacctl:", err)
+		os.Exit(2)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("srbacctl", flag.ContinueOnError)
+	dbPath := fs.String("db", envOr("SRBAC_DB", "srbac.db"), "SQLite database file, defaults to $SRBAC_DB")
+	output := fs.String("o", "table", "output format, table or json")
+	create := fs.Bool("create", false, "create the database file if it does not exist")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *output != "table" && *output != "json" {
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+	args = fs.Args()
+	if len(args) == 0 {
+		return errors.New("no command given, see srbacctl -h")
+	}
+
+	if !*create {
+		// opening a mistyped path would silently create an empty database
+		_, err = os.Stat(*dbPath)
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("database %s does not exist, pass -create to create it", *dbPath)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	c, err := openCtl(*dbPath, stdout, *output == "json")
+	if err != nil {
+		return err
+	}
+	defer c.close()
+	c.stdin, c.stderr = stdin, stderr
+
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "permissions", "roles", "users":
+		if len(args) == 0 {
+			return fmt.Errorf("%s: no subcommand given", cmd)
+		}
+		sub, args := args[0], args[1:]
+		switch cmd + " " + sub {
+		case "permissions list":
+			return c.listPermissions()
+		case "permissions create":
+			return c.createPermission(args)
+		case "permissions delete":
+			return c.deletePermissions(args)
+		case "roles list":
+			return c.listRoles()
+		case "roles create":
+			return c.createRole(args)
+		case "roles delete":
+			return c.deleteRoles(args)
+		case "users list":
+			return c.listUsers()
+		}
+		return fmt.Errorf("%s: unknown subcommand %q", cmd, sub)
+	case "assign":
+		return c.assign(args)
+	case "revoke":
+		return c.revoke(args)
+	case "check":
+		return c.check(args)
+	case "explain":
+		return c.explain(args)
+	case "export":
+		return c.export(args)
+	case "import":
+		return c.importData(args)
+	}
+	return fmt.Errorf("unknown command %q", cmd)
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
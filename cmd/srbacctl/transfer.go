@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/yinloo-ola/srbac/policy"
+	"github.com/yinloo-ola/srbac/store"
+)
+
+func (c *ctl) export(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	file := fs.String("f", "-", "file to write, - for stdout")
+	format := fs.String("format", "", "yaml, json or casbin, by default from the file extension")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	doc, err := policy.Export(c.rbac)
+	if err != nil {
+		return err
+	}
+	for _, w := range doc.Warnings {
+		fmt.Fprintln(c.stderr, "srbacctl: warning:", w)
+	}
+
+	w, f := c.stdout, policy.YAML
+	if c.json {
+		f = policy.JSON
+	}
+	if *file != "-" {
+		out, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		w, f = out, policy.FormatOf(*file)
+	}
+	if *format != "" {
+		f = policy.Format(*format)
+	}
+	return policy.Encode(w, doc, f)
+}
+
+// importData reconciles the database with a policy document and prints the
+// changes made.
+func (c *ctl) importData(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	file := fs.String("f", "-", "file to read, - for stdin")
+	prune := fs.Bool("prune", false, "delete the permissions, roles and users missing from the document")
+	dryRun := fs.Bool("dry-run", false, "only print the changes")
+	format := fs.String("format", "", "yaml, json or casbin, by default from the file extension")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	// YAML is a superset of JSON, so stdin may hold either
+	var r io.Reader = c.stdin
+	f := policy.YAML
+	if *file != "-" {
+		in, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		r, f = in, policy.FormatOf(*file)
+	}
+	if *format != "" {
+		f = policy.Format(*format)
+	}
+	doc, err := policy.Decode(r, f)
+	if err != nil {
+		return err
+	}
+
+	diff, err := policy.Reconcile(c.rbac, doc, policy.Options{Prune: *prune, DryRun: *dryRun})
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, 0, len(diff))
+	for _, change := range diff {
+		rows = append(rows, []string{string(change.Action), change.Kind, change.Name, joinDetails(change.Details)})
+	}
+	return c.print(diff, []string{"ACTION", "KIND", "NAME", "DETAILS"}, rows)
+}
+
+func equal(field string, val any) *store.WhereCond {
+	return &store.WhereCond{Field: field, Op: store.OpEqual, Val: val}
+}
@@ -0,0 +1,128 @@
+package srbac
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/yinloo-ola/srbac/models"
+)
+
+// AnomalyKind classifies an Anomaly.
+type AnomalyKind string
+
+// AnomalyDuplicateUser is reported when more than one user has the same user id.
+const AnomalyDuplicateUser AnomalyKind = "duplicate_user"
+
+// AnomalyMissingRole is reported when a user references a role that was never
+// stored. Soft deleted roles are not reported.
+const AnomalyMissingRole AnomalyKind = "missing_role"
+
+// AnomalyMissingPermission is reported when a role references a permission
+// that was never stored. Soft deleted permissions are not reported.
+const AnomalyMissingPermission AnomalyKind = "missing_permission"
+
+// Anomaly is an inconsistency between the stores of an Rbac. Only the fields
+// relevant to Kind are set.
+type Anomaly struct {
+	Kind         AnomalyKind `json:"kind"`
+	UserID       string      `json:"user_id,omitempty"`
+	RoleID       int64       `json:"role_id,omitempty"`
+	PermissionID int64       `json:"permission_id,omitempty"`
+	// Count is the number of users sharing UserID for AnomalyDuplicateUser.
+	Count int `json:"count,omitempty"`
+}
+
+func (a Anomaly) String() string {
+	switch a.Kind {
+	case AnomalyDuplicateUser:
+		return fmt.Sprintf("%s: %d users %q", a.Kind, a.Count, a.UserID)
+	case AnomalyMissingRole:
+		return fmt.Sprintf("%s: user %q references role %d", a.Kind, a.UserID, a.RoleID)
+	case AnomalyMissingPermission:
+		return fmt.Sprintf("%s: role %d references permission %d", a.Kind, a.RoleID, a.PermissionID)
+	default:
+		return string(a.Kind)
+	}
+}
+
+// CheckConsistency loads all users, roles and permissions and reports the
+// users sharing a user id, the users referencing missing roles and the roles
+// referencing missing permissions, in that order. These are the anomalies that
+// make HasPermission return ErrAmbiguousUser or ErrRoleMissing, or silently
+// ignore a grant.
+func (rbac *Rbac) CheckConsistency() ([]Anomaly, error) {
+	permissions, err := rbac.PermissionStore.FindFields([]string{"id"})
+	if err != nil {
+		return nil, fmt.Errorf("rbac.PermissionStore.FindFields failed: %w", err)
+	}
+	roles, err := rbac.RoleStore.FindWhere()
+	if err != nil {
+		return nil, fmt.Errorf("rbac.RoleStore.FindWhere failed: %w", err)
+	}
+	users, err := rbac.UserStore.FindWhere()
+	if err != nil {
+		return nil, fmt.Errorf("rbac.UserStore.FindWhere failed: %w", err)
+	}
+
+	var anomalies []Anomaly
+
+	counts := make(map[string]int, len(users))
+	for _, u := range users {
+		counts[u.UserID]++
+	}
+	var duplicates []string
+	for userID, n := range counts {
+		if n > 1 {
+			duplicates = append(duplicates, userID)
+		}
+	}
+	sort.Strings(duplicates)
+	for _, userID := range duplicates {
+		anomalies = append(anomalies, Anomaly{Kind: AnomalyDuplicateUser, UserID: userID, Count: counts[userID]})
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].Id < users[j].Id })
+	for _, u := range users {
+		missing, err := rbac.unknownRoles(u.Roles, roles)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range missing {
+			anomalies = append(anomalies, Anomaly{Kind: AnomalyMissingRole, UserID: u.UserID, RoleID: id})
+		}
+	}
+
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Id < roles[j].Id })
+	var missing []int64
+	for _, r := range roles {
+		missing = append(missing, missingPermissions(r.Permissions, permissions)...)
+	}
+	deleted, err := rbac.deletedPermissions(missing)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range roles {
+		for _, id := range missingPermissions(r.Permissions, permissions) {
+			if !deleted[id] {
+				anomalies = append(anomalies, Anomaly{Kind: AnomalyMissingPermission, RoleID: r.Id, PermissionID: id})
+			}
+		}
+	}
+	return anomalies, nil
+}
+
+// missingPermissions returns the ids of permissionIDs absent from permissions.
+func missingPermissions(permissionIDs []int64, permissions []models.Permission) []int64 {
+	found := make(map[int64]bool, len(permissions))
+	for _, p := range permissions {
+		found[p.Id] = true
+	}
+	var missing []int64
+	for _, id := range permissionIDs {
+		if !found[id] {
+			missing = append(missing, id)
+			found[id] = true
+		}
+	}
+	return missing
+}
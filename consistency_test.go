@@ -0,0 +1,70 @@
+package srbac
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yinloo-ola/srbac/helper"
+	"github.com/yinloo-ola/srbac/models"
+	"github.com/yinloo-ola/srbac/store"
+)
+
+func TestRbac_CheckConsistency(t *testing.T) {
+	assert := assert.New(t)
+	rbac := newTestRbac(t)
+
+	// duplicate users can only come from a database predating the unique index
+	db, err := sql.Open("sqlite", "file:"+t.Name()+"?mode=memory&cache=shared")
+	helper.PanicErr(err)
+	_, err = db.Exec("DROP INDEX idx_user_user_id")
+	helper.PanicErr(err)
+	helper.PanicErr(db.Close())
+
+	permIDs, err := rbac.PermissionStore.InsertMulti([]models.Permission{{Name: "orders:read"}, {Name: "orders:write"}})
+	helper.PanicErr(err)
+	roleIDs, err := rbac.RoleStore.InsertMulti([]models.Role{
+		{Name: "clerk", Permissions: []int64{permIDs[0]}},
+		{Name: "broken", Permissions: []int64{permIDs[1], 999}},
+	})
+	helper.PanicErr(err)
+	_, err = rbac.UserStore.InsertMulti([]models.User{
+		{UserID: "alice", Roles: []int64{roleIDs[0]}},
+		{UserID: "bob", Roles: []int64{roleIDs[0]}},
+		{UserID: "bob", Roles: []int64{roleIDs[1]}},
+		{UserID: "carol", Roles: []int64{roleIDs[0], 998, 998}},
+	})
+	helper.PanicErr(err)
+
+	has, err := rbac.HasPermission("alice", permIDs[0])
+	assert.NoError(err)
+	assert.True(has)
+
+	_, err = rbac.HasPermission("dave", permIDs[0])
+	assert.ErrorIs(err, ErrUserNotFound)
+	assert.ErrorIs(err, store.ErrNotFound)
+
+	_, err = rbac.HasPermission("bob", permIDs[0])
+	assert.ErrorIs(err, ErrAmbiguousUser)
+	assert.NotErrorIs(err, store.ErrNotFound)
+	_, err = rbac.GetUserPermissions("bob")
+	assert.ErrorIs(err, ErrAmbiguousUser)
+	_, err = rbac.FilterUsersWithPermission([]string{"alice", "bob"}, permIDs[0])
+	assert.ErrorIs(err, ErrAmbiguousUser)
+
+	_, err = rbac.HasPermission("carol", permIDs[0])
+	assert.ErrorIs(err, ErrRoleMissing)
+	_, err = rbac.CheckMany("carol", permIDs)
+	assert.ErrorIs(err, ErrRoleMissing)
+	_, err = rbac.FilterUsersWithPermission([]string{"alice", "carol"}, permIDs[0])
+	assert.ErrorIs(err, ErrRoleMissing)
+
+	anomalies, err := rbac.CheckConsistency()
+	assert.NoError(err)
+	assert.Equal([]Anomaly{
+		{Kind: AnomalyDuplicateUser, UserID: "bob", Count: 2},
+		{Kind: AnomalyMissingRole, UserID: "carol", RoleID: 998},
+		{Kind: AnomalyMissingPermission, RoleID: roleIDs[1], PermissionID: 999},
+	}, anomalies)
+	assert.Equal(`missing_role: user "carol" references role 998`, anomalies[1].String())
+}
@@ -0,0 +1,188 @@
+// Package grpcmw provides gRPC server interceptors enforcing srbac permissions.
+//
+// An Interceptor maps the full method name of each call to the permission it
+// requires and checks the subject (the srbac user id) of the call has it:
+//
+//	authz := grpcmw.New(rbac, grpcmw.FromMetadata("x-user-id"), grpcmw.Permissions{
+//		"/reports.v1.Reports/Get": readReports,
+//		"/reports.v1.Reports/*":   manageReports,
+//	})
+//	srv := grpc.NewServer(
+//		grpc.UnaryInterceptor(authz.Unary()),
+//		grpc.StreamInterceptor(authz.Stream()),
+//	)
+//
+// Calls without a subject fail with codes.Unauthenticated, calls whose subject
+// lacks the permission with codes.PermissionDenied.
+package grpcmw
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/yinloo-ola/srbac/store"
+)
+
+// Checker reports whether a user has a permission. *srbac.Rbac implements it.
+type Checker interface {
+	HasPermission(userID string, permissionID int64) (bool, error)
+}
+
+// Permissions maps full method names, as in "/package.Service/Method", to the
+// id of the permission they require. "/package.Service/*" applies to all the
+// methods of a service that are not listed themselves.
+type Permissions map[string]int64
+
+// lookup returns the permission required by fullMethod.
+func (o Permissions) lookup(fullMethod string) (int64, bool) {
+	if id, ok := o[fullMethod]; ok {
+		return id, true
+	}
+	i := strings.LastIndexByte(fullMethod, '/')
+	if i < 0 {
+		return 0, false
+	}
+	id, ok := o[fullMethod[:i+1]+"*"]
+	return id, ok
+}
+
+// SubjectFunc returns the srbac user id of the subject of the call with ctx.
+// It returns ErrNoSubject, or any other error, when the call is not authenticated.
+type SubjectFunc func(ctx context.Context) (string, error)
+
+// ErrNoSubject is returned by a SubjectFunc when the call carries no subject.
+var ErrNoSubject = errors.New("no subject")
+
+// FromMetadata takes the subject from the incoming metadata key. The metadata
+// must only be trusted when set by an authenticating proxy.
+func FromMetadata(key string) SubjectFunc {
+	return func(ctx context.Context) (string, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		vals := md.Get(key)
+		if len(vals) == 0 || vals[0] == "" {
+			return "", ErrNoSubject
+		}
+		return vals[0], nil
+	}
+}
+
+// FromContext takes the subject from the string value of key in the call
+// context, as set by an authenticating interceptor running before.
+func FromContext(key any) SubjectFunc {
+	return func(ctx context.Context) (string, error) {
+		userID, _ := ctx.Value(key).(string)
+		if userID == "" {
+			return "", ErrNoSubject
+		}
+		return userID, nil
+	}
+}
+
+// Interceptor checks the permissions of calls against a Checker.
+type Interceptor struct {
+	checker       Checker
+	subject       SubjectFunc
+	permissions   Permissions
+	allowUnlisted bool
+}
+
+// Option configures an Interceptor.
+type Option func(*Interceptor)
+
+// WithAllowUnlisted lets through calls to methods missing from Permissions
+// without checking their subject. By default they fail with codes.PermissionDenied.
+func WithAllowUnlisted() Option {
+	return func(o *Interceptor) {
+		o.allowUnlisted = true
+	}
+}
+
+// New returns an Interceptor checking the subject returned by subject against
+// checker for the permission of each method in permissions.
+func New(checker Checker, subject SubjectFunc, permissions Permissions, opts ...Option) *Interceptor {
+	o := &Interceptor{checker: checker, subject: subject, permissions: permissions}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// authorize returns ctx carrying the subject of the call to fullMethod, or the
+// status error the call fails with.
+func (o *Interceptor) authorize(ctx context.Context, fullMethod string) (context.Context, error) {
+	permissionID, ok := o.permissions.lookup(fullMethod)
+	if !ok {
+		if o.allowUnlisted {
+			return ctx, nil
+		}
+		return nil, status.Errorf(codes.PermissionDenied, "%s requires an unknown permission", fullMethod)
+	}
+
+	userID, err := o.subject(ctx)
+	if err == nil && userID == "" {
+		err = ErrNoSubject
+	}
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	ok, err = o.checker.HasPermission(userID, permissionID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, status.Errorf(codes.PermissionDenied, "unknown user %q", userID)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "permission check failed: %v", err)
+	}
+	if !ok {
+		return nil, status.Errorf(codes.PermissionDenied, "%q may not call %s", userID, fullMethod)
+	}
+	return context.WithValue(ctx, subjectKey{}, userID), nil
+}
+
+// Unary returns the unary server interceptor. The subject is available to
+// handlers through Subject.
+func (o *Interceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := o.authorize(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Stream returns the stream server interceptor. The subject is available to
+// handlers through Subject on the stream context.
+func (o *Interceptor) Stream() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := o.authorize(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// serverStream overrides the context of a grpc.ServerStream.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (o *serverStream) Context() context.Context {
+	return o.ctx
+}
+
+type subjectKey struct{}
+
+// Subject returns the subject of a call let through by an Interceptor.
+func Subject(ctx context.Context) (string, bool) {
+	userID, ok := ctx.Value(subjectKey{}).(string)
+	return userID, ok
+}
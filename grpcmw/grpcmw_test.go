@@ -0,0 +1,152 @@
+package grpcmw
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/yinloo-ola/srbac"
+	"github.com/yinloo-ola/srbac/store"
+)
+
+var _ Checker = (*srbac.Rbac)(nil)
+
+// fakeChecker grants the permissions listed for each user.
+type fakeChecker map[string][]int64
+
+func (o fakeChecker) HasPermission(userID string, permissionID int64) (bool, error) {
+	if userID == "broken" {
+		return false, errors.New("store down")
+	}
+	perms, ok := o[userID]
+	if !ok {
+		return false, store.ErrNotFound
+	}
+	for _, p := range perms {
+		if p == permissionID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// subjectHealth records the subject seen by Check.
+type subjectHealth struct {
+	*health.Server
+	mu      sync.Mutex
+	subject string
+}
+
+func (o *subjectHealth) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	o.mu.Lock()
+	o.subject, _ = Subject(ctx)
+	o.mu.Unlock()
+	return o.Server.Check(ctx, req)
+}
+
+func newTestClient(t *testing.T, authz *Interceptor) (healthpb.HealthClient, *subjectHealth) {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.UnaryInterceptor(authz.Unary()), grpc.StreamInterceptor(authz.Stream()))
+	svc := &subjectHealth{Server: health.NewServer()}
+	healthpb.RegisterHealthServer(srv, svc)
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("fail to dial bufnet: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+		srv.Stop()
+	})
+	return healthpb.NewHealthClient(conn), svc
+}
+
+func withUser(userID string) context.Context {
+	ctx := context.Background()
+	if userID == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, "x-user-id", userID)
+}
+
+func TestUnary(t *testing.T) {
+	checker := fakeChecker{"alice": {1}, "bob": {2}}
+	authz := New(checker, FromMetadata("x-user-id"), Permissions{"/grpc.health.v1.Health/Check": 1})
+	client, svc := newTestClient(t, authz)
+
+	tests := []struct {
+		user string
+		code codes.Code
+	}{
+		{"alice", codes.OK},
+		{"bob", codes.PermissionDenied},
+		{"mallory", codes.PermissionDenied},
+		{"", codes.Unauthenticated},
+		{"broken", codes.Internal},
+	}
+	for _, tt := range tests {
+		_, err := client.Check(withUser(tt.user), &healthpb.HealthCheckRequest{})
+		assert.Equal(t, tt.code, status.Code(err), tt.user)
+	}
+
+	_, err := client.Check(withUser("alice"), &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	svc.mu.Lock()
+	assert.Equal(t, "alice", svc.subject)
+	svc.mu.Unlock()
+}
+
+func TestStream(t *testing.T) {
+	checker := fakeChecker{"alice": {2}, "bob": {1}}
+	authz := New(checker, FromMetadata("x-user-id"), Permissions{"/grpc.health.v1.Health/*": 2})
+	client, _ := newTestClient(t, authz)
+
+	watch := func(userID string) error {
+		ctx, cancel := context.WithCancel(withUser(userID))
+		defer cancel()
+		stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+		if err != nil {
+			return err
+		}
+		_, err = stream.Recv()
+		return err
+	}
+	assert.NoError(t, watch("alice"))
+	assert.Equal(t, codes.PermissionDenied, status.Code(watch("bob")))
+	assert.Equal(t, codes.Unauthenticated, status.Code(watch("")))
+
+	// the service wildcard applies to unary methods too
+	_, err := client.Check(withUser("alice"), &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+}
+
+func TestUnlistedMethods(t *testing.T) {
+	checker := fakeChecker{"alice": {1}}
+	client, _ := newTestClient(t, New(checker, FromMetadata("x-user-id"), Permissions{}))
+	_, err := client.Check(withUser("alice"), &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	client, _ = newTestClient(t, New(checker, FromMetadata("x-user-id"), Permissions{}, WithAllowUnlisted()))
+	_, err = client.Check(withUser(""), &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+}
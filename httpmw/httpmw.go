@@ -0,0 +1,135 @@
+// Package httpmw provides net/http middleware enforcing srbac permissions.
+//
+// A Middleware extracts the subject (the srbac user id) of each request with a
+// SubjectFunc and checks it has the permission required by the route:
+//
+//	mw := httpmw.New(rbac, httpmw.FromHeader("X-User-Id"))
+//	mux.Handle("/reports", mw.Require(readReports)(reportsHandler))
+//
+// Requests without a valid subject get 401, subjects without the permission 403.
+package httpmw
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/yinloo-ola/srbac/store"
+)
+
+// Checker reports whether a user has a permission. *srbac.Rbac implements it.
+type Checker interface {
+	HasPermission(userID string, permissionID int64) (bool, error)
+}
+
+// ErrNoSubject is returned by a SubjectFunc when the request carries no subject.
+var ErrNoSubject = errors.New("no subject")
+
+// ErrForbidden is passed to the forbidden handler when the subject lacks the
+// required permission.
+var ErrForbidden = errors.New("permission denied")
+
+// ErrorHandler writes the response of a request rejected with err.
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+
+// Middleware checks the permissions of requests against a Checker.
+type Middleware struct {
+	checker      Checker
+	subject      SubjectFunc
+	unauthorized ErrorHandler
+	forbidden    ErrorHandler
+	failed       ErrorHandler
+}
+
+// Option configures a Middleware.
+type Option func(*Middleware)
+
+// WithUnauthorized sets the handler of requests without a valid subject.
+// The default responds 401 Unauthorized.
+func WithUnauthorized(h ErrorHandler) Option {
+	return func(m *Middleware) {
+		m.unauthorized = h
+	}
+}
+
+// WithForbidden sets the handler of requests whose subject lacks the required
+// permission or is not a known user. The default responds 403 Forbidden.
+func WithForbidden(h ErrorHandler) Option {
+	return func(m *Middleware) {
+		m.forbidden = h
+	}
+}
+
+// WithErrorHandler sets the handler of requests whose permission check failed.
+// The default responds 500 Internal Server Error.
+func WithErrorHandler(h ErrorHandler) Option {
+	return func(m *Middleware) {
+		m.failed = h
+	}
+}
+
+// New returns a Middleware checking the subject returned by subject against checker.
+func New(checker Checker, subject SubjectFunc, opts ...Option) *Middleware {
+	m := &Middleware{
+		checker:      checker,
+		subject:      subject,
+		unauthorized: statusHandler(http.StatusUnauthorized),
+		forbidden:    statusHandler(http.StatusForbidden),
+		failed:       statusHandler(http.StatusInternalServerError),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Require returns middleware only passing on requests whose subject has
+// permissionID. The subject is available to next through Subject.
+func (m *Middleware) Require(permissionID int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, err := m.subject(r)
+			if err == nil && userID == "" {
+				err = ErrNoSubject
+			}
+			if err != nil {
+				m.unauthorized(w, r, err)
+				return
+			}
+
+			ok, err := m.checker.HasPermission(userID, permissionID)
+			if errors.Is(err, store.ErrNotFound) {
+				m.forbidden(w, r, err)
+				return
+			}
+			if err != nil {
+				m.failed(w, r, err)
+				return
+			}
+			if !ok {
+				m.forbidden(w, r, ErrForbidden)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), subjectKey{}, userID)))
+		})
+	}
+}
+
+// RequireFunc is Require for a handler function.
+func (m *Middleware) RequireFunc(permissionID int64, next http.HandlerFunc) http.Handler {
+	return m.Require(permissionID)(next)
+}
+
+type subjectKey struct{}
+
+// Subject returns the subject of a request passed on by Require.
+func Subject(ctx context.Context) (string, bool) {
+	userID, ok := ctx.Value(subjectKey{}).(string)
+	return userID, ok
+}
+
+func statusHandler(status int) ErrorHandler {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		http.Error(w, http.StatusText(status), status)
+	}
+}
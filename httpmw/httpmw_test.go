@@ -0,0 +1,164 @@
+package httpmw
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yinloo-ola/srbac"
+	"github.com/yinloo-ola/srbac/store"
+)
+
+var _ Checker = (*srbac.Rbac)(nil)
+
+// fakeChecker grants the permissions listed for each user.
+type fakeChecker map[string][]int64
+
+func (o fakeChecker) HasPermission(userID string, permissionID int64) (bool, error) {
+	if userID == "broken" {
+		return false, errors.New("store down")
+	}
+	perms, ok := o[userID]
+	if !ok {
+		return false, store.ErrNotFound
+	}
+	for _, p := range perms {
+		if p == permissionID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestRequire(t *testing.T) {
+	checker := fakeChecker{"alice": {1, 2}, "bob": {3}}
+	mw := New(checker, FromHeader("X-User-Id"))
+	h := mw.RequireFunc(2, func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := Subject(r.Context())
+		assert.True(t, ok)
+		_, _ = w.Write([]byte("hello " + userID))
+	})
+
+	tests := []struct {
+		user   string
+		status int
+	}{
+		{"alice", http.StatusOK},
+		{"bob", http.StatusForbidden},
+		{"mallory", http.StatusForbidden},
+		{"", http.StatusUnauthorized},
+		{"broken", http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.user != "" {
+			r.Header.Set("X-User-Id", tt.user)
+		}
+		w := serve(h, r)
+		assert.Equal(t, tt.status, w.Code, tt.user)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-User-Id", "alice")
+	assert.Equal(t, "hello alice", serve(h, r).Body.String())
+}
+
+func TestCustomResponses(t *testing.T) {
+	var unauthorizedErr, forbiddenErr error
+	mw := New(fakeChecker{"bob": {3}}, FromHeader("X-User-Id"),
+		WithUnauthorized(func(w http.ResponseWriter, r *http.Request, err error) {
+			unauthorizedErr = err
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+		}),
+		WithForbidden(func(w http.ResponseWriter, r *http.Request, err error) {
+			forbiddenErr = err
+			w.WriteHeader(http.StatusNotFound)
+		}),
+	)
+	h := mw.Require(2)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatalf("handler must not be called")
+	}))
+
+	w := serve(h, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+	assert.ErrorIs(t, unauthorizedErr, ErrNoSubject)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-User-Id", "bob")
+	w = serve(h, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.ErrorIs(t, forbiddenErr, ErrForbidden)
+}
+
+type userKey struct{}
+
+func TestFromContext(t *testing.T) {
+	mw := New(fakeChecker{"alice": {1}}, FromContext(userKey{}))
+	h := mw.Require(1)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.Equal(t, http.StatusUnauthorized, serve(h, r).Code)
+
+	r = r.WithContext(context.WithValue(r.Context(), userKey{}, "alice"))
+	assert.Equal(t, http.StatusOK, serve(h, r).Code)
+}
+
+// mapClaims is a named claims map, like jwt.MapClaims.
+type mapClaims map[string]any
+
+type claimsKey struct{}
+
+func TestFromClaims(t *testing.T) {
+	mw := New(fakeChecker{"alice": {1}}, FromClaims(claimsKey{}, "sub"))
+	h := mw.Require(1)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	withClaims := func(claims any) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		return r.WithContext(context.WithValue(r.Context(), claimsKey{}, claims))
+	}
+	assert.Equal(t, http.StatusUnauthorized, serve(h, httptest.NewRequest(http.MethodGet, "/", nil)).Code)
+	assert.Equal(t, http.StatusOK, serve(h, withClaims(map[string]any{"sub": "alice"})).Code)
+	assert.Equal(t, http.StatusOK, serve(h, withClaims(mapClaims{"sub": "alice"})).Code)
+	assert.Equal(t, http.StatusForbidden, serve(h, withClaims(mapClaims{"sub": "bob"})).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(h, withClaims(mapClaims{"email": "alice@example.com"})).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(h, withClaims(mapClaims{"sub": 42})).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(h, withClaims("alice")).Code)
+}
+
+func TestSubjectFunc(t *testing.T) {
+	errBadToken := errors.New("bad token")
+	subject := func(r *http.Request) (string, error) {
+		token := r.Header.Get("Authorization")
+		if token == "" {
+			return "", ErrNoSubject
+		}
+		if token != "Bearer alice-token" {
+			return "", errBadToken
+		}
+		return "alice", nil
+	}
+	var unauthorizedErr error
+	mw := New(fakeChecker{"alice": {1}}, subject, WithUnauthorized(func(w http.ResponseWriter, r *http.Request, err error) {
+		unauthorizedErr = err
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	h := mw.Require(1)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer alice-token")
+	assert.Equal(t, http.StatusOK, serve(h, r).Code)
+	r.Header.Set("Authorization", "Bearer mallory-token")
+	assert.Equal(t, http.StatusUnauthorized, serve(h, r).Code)
+	assert.ErrorIs(t, unauthorizedErr, errBadToken)
+}
@@ -0,0 +1,58 @@
+package httpmw
+
+import (
+	"net/http"
+	"reflect"
+)
+
+// SubjectFunc returns the srbac user id of the subject of r. It returns
+// ErrNoSubject, or any other error, when r is not authenticated. Applications
+// authenticating with tokens verify them with their own token library, in a
+// middleware storing the claims for FromClaims or in a SubjectFunc of their own.
+type SubjectFunc func(r *http.Request) (string, error)
+
+// FromHeader takes the subject from the request header name. The header must
+// only be trusted when set by an authenticating proxy.
+func FromHeader(name string) SubjectFunc {
+	return func(r *http.Request) (string, error) {
+		userID := r.Header.Get(name)
+		if userID == "" {
+			return "", ErrNoSubject
+		}
+		return userID, nil
+	}
+}
+
+// FromContext takes the subject from the string value of key in the request
+// context, as set by an authenticating middleware running before.
+func FromContext(key any) SubjectFunc {
+	return func(r *http.Request) (string, error) {
+		userID, _ := r.Context().Value(key).(string)
+		if userID == "" {
+			return "", ErrNoSubject
+		}
+		return userID, nil
+	}
+}
+
+// FromClaims takes the subject from the string claim of the verified token
+// claims stored under key in the request context, as set by an authenticating
+// middleware running before. The claims are a map with string keys, such as
+// map[string]any or jwt.MapClaims. FromClaims does not verify anything itself.
+func FromClaims(key any, claim string) SubjectFunc {
+	return func(r *http.Request) (string, error) {
+		claims := reflect.ValueOf(r.Context().Value(key))
+		if claims.Kind() != reflect.Map || claims.Type().Key().Kind() != reflect.String {
+			return "", ErrNoSubject
+		}
+		v := claims.MapIndex(reflect.ValueOf(claim).Convert(claims.Type().Key()))
+		if !v.IsValid() {
+			return "", ErrNoSubject
+		}
+		userID, _ := v.Interface().(string)
+		if userID == "" {
+			return "", ErrNoSubject
+		}
+		return userID, nil
+	}
+}
@@ -1,17 +1,30 @@
 package models
 
-import "github.com/yinloo-ola/srbac/store"
+import (
+	"time"
+
+	"github.com/yinloo-ola/srbac/store"
+)
 
 type Permission struct {
-	Id          int64  `db:"id,pk"`
-	Name        string `db:"name"`
-	Description string `db:"description"`
+	Id          int64     `db:"id,pk" json:"id"`
+	Name        string    `db:"name" json:"name"`
+	Description string    `db:"description" json:"description"`
+	CreatedAt   time.Time `db:"created_at,autocreate" json:"created_at"`
+	UpdatedAt   time.Time `db:"updated_at,autoupdate" json:"updated_at"`
+	DeletedAt   int64     `db:"deleted_at,soft_delete" json:"-"`
+}
+
+// Indexes leaves deleted rows out of the unique indexes, here and in Role
+// and User, so that their names can be used again.
+func (o *Permission) Indexes() []store.Index {
+	return []store.Index{{Name: "name", Columns: []string{"name"}, Unique: true, Where: "deleted_at = 0"}}
 }
 
 func (o *Permission) FieldsVals() []any {
-	return []any{o.Id, o.Name, o.Description}
+	return []any{o.Id, o.Name, o.Description, o.CreatedAt, o.UpdatedAt, o.DeletedAt}
 }
 
 func (o *Permission) ScanRow(row store.RowScanner) error {
-	return row.Scan(&o.Id, &o.Name, &o.Description)
+	return row.Scan(&o.Id, &o.Name, &o.Description, &o.CreatedAt, &o.UpdatedAt, &o.DeletedAt)
 }
@@ -2,27 +2,36 @@ package models
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/yinloo-ola/srbac/helper"
 	"github.com/yinloo-ola/srbac/store"
 )
 
 type Role struct {
-	Id          int64   `db:"id,pk"`
-	Name        string  `db:"name"`
-	Description string  `db:"description"`
-	Permissions []int64 `db:"permissions,json"`
+	Id          int64     `db:"id,pk" json:"id"`
+	Name        string    `db:"name" json:"name"`
+	Description string    `db:"description" json:"description"`
+	Permissions []int64   `db:"permissions,json" json:"permissions"`
+	Version     int64     `db:"version,version" json:"version"`
+	CreatedAt   time.Time `db:"created_at,autocreate" json:"created_at"`
+	UpdatedAt   time.Time `db:"updated_at,autoupdate" json:"updated_at"`
+	DeletedAt   int64     `db:"deleted_at,soft_delete" json:"-"`
+}
+
+func (o *Role) Indexes() []store.Index {
+	return []store.Index{{Name: "name", Columns: []string{"name"}, Unique: true, Where: "deleted_at = 0"}}
 }
 
 func (o *Role) FieldsVals() []any {
 	perms, err := json.Marshal(o.Permissions)
 	helper.PanicErr(err)
-	return []any{o.Id, o.Name, o.Description, perms}
+	return []any{o.Id, o.Name, o.Description, perms, o.Version, o.CreatedAt, o.UpdatedAt, o.DeletedAt}
 }
 
 func (o *Role) ScanRow(row store.RowScanner) error {
 	var perms []byte
-	err := row.Scan(&o.Id, &o.Name, &o.Description, &perms)
+	err := row.Scan(&o.Id, &o.Name, &o.Description, &perms, &o.Version, &o.CreatedAt, &o.UpdatedAt, &o.DeletedAt)
 	if err != nil {
 		return err
 	}
@@ -2,26 +2,37 @@ package models
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/yinloo-ola/srbac/helper"
 	"github.com/yinloo-ola/srbac/store"
 )
 
 type User struct {
-	Id     int64   `db:"id,pk"`
-	UserID string  `db:"user_id,idx_asc,uniq"`
-	Roles  []int64 `db:"roles,json"`
+	Id          int64      `db:"id,pk" json:"id"`
+	UserID      string     `db:"user_id" json:"user_id"`
+	Roles       []int64    `db:"roles,json" json:"roles"`
+	Version     int64      `db:"version,version" json:"version"`
+	Email       *string    `db:"email" json:"email,omitempty"`
+	LastLoginAt *time.Time `db:"last_login_at" json:"last_login_at,omitempty"`
+	CreatedAt   time.Time  `db:"created_at,autocreate" json:"created_at"`
+	UpdatedAt   time.Time  `db:"updated_at,autoupdate" json:"updated_at"`
+	DeletedAt   int64      `db:"deleted_at,soft_delete" json:"-"`
+}
+
+func (o *User) Indexes() []store.Index {
+	return []store.Index{{Name: "user_id", Columns: []string{"user_id"}, Unique: true, Where: "deleted_at = 0"}}
 }
 
 func (o *User) FieldsVals() []any {
 	roles, err := json.Marshal(o.Roles)
 	helper.PanicErr(err)
-	return []any{o.Id, o.UserID, roles}
+	return []any{o.Id, o.UserID, roles, o.Version, o.Email, o.LastLoginAt, o.CreatedAt, o.UpdatedAt, o.DeletedAt}
 }
 
 func (o *User) ScanRow(row store.RowScanner) error {
 	var roles []byte
-	err := row.Scan(&o.Id, &o.UserID, &roles)
+	err := row.Scan(&o.Id, &o.UserID, &roles, &o.Version, &o.Email, &o.LastLoginAt, &o.CreatedAt, &o.UpdatedAt, &o.DeletedAt)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,237 @@
+package srbac
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yinloo-ola/srbac/store"
+)
+
+// nameCache caches the ids of the rows of a store by name while the store
+// implements store.ChangeFeed. Before each lookup the cache takes the pending
+// events of its subscription, which the store publishes before a write
+// returns, and drops the entries of the changed ids, so writes through the
+// same store are seen right away. Writes through other handles or processes
+// are only published to their own subscribers; they are caught by comparing
+// the persisted LastSeq with the latest sequence number seen, at most every
+// namePollInterval, which bounds how long an entry can be stale. Without a
+// change feed every lookup queries the store.
+type nameCache struct {
+	lookup func(name string) ([]int64, error)
+	feed   store.ChangeFeed
+
+	mu     sync.Mutex
+	ids    map[string]int64
+	gen    uint64
+	events <-chan store.ChangeEvent
+	cancel func()
+	closed bool
+	seq    int64
+	polled time.Time
+}
+
+// namePollInterval is how often a nameCache checks the change log for writes
+// made through other handles or processes.
+var namePollInterval = time.Second
+
+// changeFeedBuffer is the buffer of the change subscription of a nameCache.
+// The subscription is dropped by the store when it overflows and made again on
+// the next lookup.
+const changeFeedBuffer = 64
+
+func newNameCache[T any, R store.Row[T]](s store.Store[T, R], lookup func(name string) ([]int64, error)) *nameCache {
+	c := &nameCache{lookup: lookup, ids: map[string]int64{}}
+	c.feed, _ = s.(store.ChangeFeed)
+	return c
+}
+
+// id returns the id of the row named name, or store.ErrNotFound.
+func (c *nameCache) id(name string) (int64, error) {
+	if c.feed == nil {
+		return c.resolve(name)
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return c.resolve(name)
+	}
+	err := c.catchUp()
+	if err != nil {
+		c.mu.Unlock()
+		return 0, err
+	}
+	id, ok := c.ids[name]
+	gen := c.gen
+	c.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	id, err = c.resolve(name)
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	// a change since gen was read may have made id stale
+	if c.gen == gen && !c.closed {
+		c.ids[name] = id
+	}
+	c.mu.Unlock()
+	return id, nil
+}
+
+// catchUp applies the pending change events and, every namePollInterval, the
+// changes made elsewhere. c.mu must be held.
+func (c *nameCache) catchUp() error {
+	if c.events == nil {
+		c.events, c.cancel = c.feed.Subscribe(changeFeedBuffer)
+		c.reset()
+		c.polled = time.Time{}
+	}
+	for drained := false; !drained; {
+		select {
+		case event, ok := <-c.events:
+			if !ok {
+				// dropped for falling behind
+				c.events, c.cancel = c.feed.Subscribe(changeFeedBuffer)
+				c.reset()
+				c.polled = time.Time{}
+				continue
+			}
+			c.forget(event.IDs)
+			if event.Seq > c.seq {
+				c.seq = event.Seq
+			}
+		default:
+			drained = true
+		}
+	}
+
+	if time.Since(c.polled) < namePollInterval {
+		return nil
+	}
+	seq, err := c.feed.LastSeq()
+	if err != nil {
+		return err
+	}
+	if seq != c.seq {
+		c.reset()
+		c.seq = seq
+	}
+	c.polled = time.Now()
+	return nil
+}
+
+// forget drops the entries of ids. c.mu must be held.
+func (c *nameCache) forget(ids []int64) {
+	c.gen++
+	for name, id := range c.ids {
+		for _, changed := range ids {
+			if id == changed {
+				delete(c.ids, name)
+				break
+			}
+		}
+	}
+}
+
+// reset drops all entries. c.mu must be held.
+func (c *nameCache) reset() {
+	c.gen++
+	if len(c.ids) > 0 {
+		c.ids = map[string]int64{}
+	}
+}
+
+// close stops watching the change feed. Later lookups query the store.
+func (c *nameCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	c.reset()
+	if c.cancel != nil {
+		c.cancel()
+		c.cancel, c.events = nil, nil
+	}
+}
+
+// resolve looks up the id of the row named name in the store.
+func (c *nameCache) resolve(name string) (int64, error) {
+	ids, err := c.lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) != 1 {
+		return 0, store.ErrNotFound
+	}
+	return ids[0], nil
+}
+
+// nameCaches returns the name caches of rbac, creating them on first use so
+// that an Rbac built without NewRbac works too.
+func (rbac *Rbac) nameCaches() (*nameCache, *nameCache) {
+	rbac.namesOnce.Do(func() {
+		rbac.permissionNames = newNameCache(rbac.PermissionStore, func(name string) ([]int64, error) {
+			perms, err := rbac.PermissionStore.FindFields([]string{"id"}, nameCond(name))
+			if err != nil {
+				return nil, fmt.Errorf("rbac.PermissionStore.FindFields failed: %w", err)
+			}
+			ids := make([]int64, 0, len(perms))
+			for _, p := range perms {
+				ids = append(ids, p.Id)
+			}
+			return ids, nil
+		})
+		rbac.roleNames = newNameCache(rbac.RoleStore, func(name string) ([]int64, error) {
+			roles, err := rbac.RoleStore.FindFields([]string{"id"}, nameCond(name))
+			if err != nil {
+				return nil, fmt.Errorf("rbac.RoleStore.FindFields failed: %w", err)
+			}
+			ids := make([]int64, 0, len(roles))
+			for _, r := range roles {
+				ids = append(ids, r.Id)
+			}
+			return ids, nil
+		})
+	})
+	return rbac.permissionNames, rbac.roleNames
+}
+
+func nameCond(name string) *store.WhereCond {
+	return &store.WhereCond{Field: "name", Val: name, Op: store.OpEqual}
+}
+
+// PermissionID returns the id of the permission named name, or
+// store.ErrNotFound. Ids are cached while the PermissionStore implements
+// store.ChangeFeed, so that a lookup usually runs no query; see nameCache.
+func (rbac *Rbac) PermissionID(name string) (int64, error) {
+	permissionNames, _ := rbac.nameCaches()
+	id, err := permissionNames.id(name)
+	if err != nil {
+		return 0, fmt.Errorf("permission %q: %w", name, err)
+	}
+	return id, nil
+}
+
+// RoleID returns the id of the role named name, or store.ErrNotFound. Ids are
+// cached while the RoleStore implements store.ChangeFeed; see nameCache.
+func (rbac *Rbac) RoleID(name string) (int64, error) {
+	_, roleNames := rbac.nameCaches()
+	id, err := roleNames.id(name)
+	if err != nil {
+		return 0, fmt.Errorf("role %q: %w", name, err)
+	}
+	return id, nil
+}
+
+// HasPermissionByName is HasPermission for the permission named permissionName,
+// as in HasPermissionByName("alice", "orders:write").
+func (rbac *Rbac) HasPermissionByName(userID string, permissionName string) (bool, error) {
+	permissionID, err := rbac.PermissionID(permissionName)
+	if err != nil {
+		return false, err
+	}
+	return rbac.HasPermission(userID, permissionID)
+}
@@ -0,0 +1,164 @@
+package policy
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// decodeCasbin reads the p and g lines of a Casbin RBAC policy:
+//
+//	p, analyst, reports, read
+//	g, alice, analyst
+//
+// Each p line grants the permission named obj:act to the role sub, each g line
+// gives a user a role. Casbin roles may inherit other roles with g lines
+// between roles; srbac has no role hierarchy, so users are given all the roles
+// they inherit instead. Lines with an effect other than allow are rejected, as
+// are users given permissions directly with p lines: a subject of both p and g
+// lines is only taken for a role when it is itself given to someone.
+func decodeCasbin(r io.Reader) (*Document, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	rolePerms := map[string][]string{}
+	var roleOrder, userOrder []string
+	var permOrder []string
+	perms := map[string]bool{}
+	parents := map[string][]string{}
+	userLine := map[string]int{}
+	for {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid casbin policy: %w", err)
+		}
+		for i := range rec {
+			rec[i] = strings.TrimSpace(rec[i])
+		}
+		line, _ := cr.FieldPos(0)
+		switch rec[0] {
+		case "p":
+			if len(rec) == 5 && rec[4] != "allow" {
+				return nil, fmt.Errorf("casbin policy line %d: only allow effects are supported", line)
+			}
+			if len(rec) != 4 && len(rec) != 5 {
+				return nil, fmt.Errorf("casbin policy line %d: p expects sub, obj, act", line)
+			}
+			role, perm := rec[1], rec[2]+":"+rec[3]
+			if _, ok := rolePerms[role]; !ok {
+				roleOrder = append(roleOrder, role)
+			}
+			rolePerms[role] = append(rolePerms[role], perm)
+			if !perms[perm] {
+				perms[perm] = true
+				permOrder = append(permOrder, perm)
+			}
+		case "g":
+			if len(rec) != 3 {
+				return nil, fmt.Errorf("casbin policy line %d: g expects user, role", line)
+			}
+			if _, ok := parents[rec[1]]; !ok {
+				userOrder = append(userOrder, rec[1])
+				userLine[rec[1]] = line
+			}
+			parents[rec[1]] = append(parents[rec[1]], rec[2])
+		default:
+			return nil, fmt.Errorf("casbin policy line %d: unsupported policy type %q", line, rec[0])
+		}
+	}
+
+	// roles are the subjects of p lines and the targets of g lines
+	isRole := map[string]bool{}
+	for role := range rolePerms {
+		isRole[role] = true
+	}
+	held := map[string]bool{}
+	for _, user := range userOrder {
+		for _, role := range parents[user] {
+			held[role] = true
+			if !isRole[role] {
+				isRole[role] = true
+				roleOrder = append(roleOrder, role)
+			}
+		}
+	}
+
+	// a subject with permissions of its own and roles, that no one holds, is a
+	// user granted permissions directly, which srbac cannot express
+	for _, user := range userOrder {
+		if _, ok := rolePerms[user]; ok && !held[user] {
+			return nil, fmt.Errorf("casbin policy line %d: %q is given roles and permissions directly, grant its permissions through a role", userLine[user], user)
+		}
+	}
+
+	doc := &Document{Permissions: []Permission{}, Roles: []Role{}, Users: []User{}}
+	for _, perm := range permOrder {
+		doc.Permissions = append(doc.Permissions, Permission{Name: perm})
+	}
+	for _, role := range roleOrder {
+		doc.Roles = append(doc.Roles, Role{Name: role, Permissions: sortedUnique(rolePerms[role])})
+	}
+	for _, user := range userOrder {
+		if isRole[user] {
+			continue
+		}
+		doc.Users = append(doc.Users, User{UserID: user, Roles: inheritedRoles(user, parents)})
+	}
+	return doc, nil
+}
+
+// inheritedRoles returns the sorted roles of user and the roles they inherit.
+func inheritedRoles(user string, parents map[string][]string) []string {
+	seen := map[string]bool{}
+	var roles []string
+	stack := append([]string(nil), parents[user]...)
+	for len(stack) > 0 {
+		role := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[role] {
+			continue
+		}
+		seen[role] = true
+		roles = append(roles, role)
+		stack = append(stack, parents[role]...)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// encodeCasbin writes doc as Casbin p and g lines. Permission names are split
+// into obj and act at their last colon. Descriptions, roles without
+// permissions and users without roles have no Casbin equivalent and are lost.
+func encodeCasbin(w io.Writer, doc *Document) error {
+	cw := csv.NewWriter(w)
+	for _, r := range doc.Roles {
+		for _, p := range r.Permissions {
+			i := strings.LastIndexByte(p, ':')
+			if i < 0 {
+				return fmt.Errorf("permission %q is not named obj:act", p)
+			}
+			err := cw.Write([]string{"p", r.Name, p[:i], p[i+1:]})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, u := range doc.Users {
+		for _, r := range u.Roles {
+			err := cw.Write([]string{"g", u.UserID, r})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
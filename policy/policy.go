@@ -0,0 +1,325 @@
+// Package policy exports the permissions, roles and users of an srbac.Rbac to
+// a YAML or JSON document and reconciles an Rbac to match such a document, so
+// that the RBAC configuration can be kept in version control. Casbin RBAC
+// policy CSV files can be read and written as documents too.
+//
+// Documents reference permissions and roles by name rather than by id:
+//
+//	permissions:
+//	  - name: reports:read
+//	    description: Read reports
+//	roles:
+//	  - name: analyst
+//	    permissions: [reports:read]
+//	users:
+//	  - user_id: alice
+//	    roles: [analyst]
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/yinloo-ola/srbac"
+	"github.com/yinloo-ola/srbac/models"
+)
+
+// Document is the permission, role and user graph of an Rbac. Export sorts all
+// of its lists by name so that exports of the same data are identical.
+type Document struct {
+	Permissions []Permission `yaml:"permissions" json:"permissions"`
+	Roles       []Role       `yaml:"roles" json:"roles"`
+	Users       []User       `yaml:"users" json:"users"`
+	// Warnings lists the dangling references Export left out. It is not
+	// encoded.
+	Warnings []string `yaml:"-" json:"-"`
+}
+
+type Permission struct {
+	Name        string `yaml:"name" json:"name"`
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+}
+
+type Role struct {
+	Name        string   `yaml:"name" json:"name"`
+	Description string   `yaml:"description,omitempty" json:"description,omitempty"`
+	Permissions []string `yaml:"permissions" json:"permissions"`
+}
+
+type User struct {
+	UserID string   `yaml:"user_id" json:"user_id"`
+	Roles  []string `yaml:"roles" json:"roles"`
+}
+
+// Format is the encoding of a Document.
+type Format string
+
+const (
+	YAML Format = "yaml"
+	JSON Format = "json"
+	// Casbin is the CSV policy format of Casbin's RBAC model. See decodeCasbin
+	// for how it maps to a Document.
+	Casbin Format = "casbin"
+)
+
+// FormatOf returns the format of a file from its extension: JSON for .json,
+// Casbin for .csv and YAML otherwise.
+func FormatOf(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return JSON
+	case ".csv":
+		return Casbin
+	}
+	return YAML
+}
+
+// Encode writes doc to w in format.
+func Encode(w io.Writer, doc *Document, format Format) error {
+	switch format {
+	case YAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		err := enc.Encode(doc)
+		if err != nil {
+			return err
+		}
+		return enc.Close()
+	case JSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(doc)
+	case Casbin:
+		return encodeCasbin(w, doc)
+	}
+	return fmt.Errorf("unknown policy format %q", format)
+}
+
+// Decode reads a Document in format from r and validates it.
+func Decode(r io.Reader, format Format) (*Document, error) {
+	if format == Casbin {
+		doc, err := decodeCasbin(r)
+		if err != nil {
+			return nil, err
+		}
+		err = doc.Validate()
+		if err != nil {
+			return nil, err
+		}
+		return doc, nil
+	}
+
+	var doc Document
+	var err error
+	switch format {
+	case YAML:
+		dec := yaml.NewDecoder(r)
+		dec.KnownFields(true)
+		err = dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+	case JSON:
+		dec := json.NewDecoder(r)
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&doc)
+	default:
+		return nil, fmt.Errorf("unknown policy format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy document: %w", err)
+	}
+	err = doc.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// Validate checks that names are set and unique and that all referenced
+// permissions and roles are in doc.
+func (doc *Document) Validate() error {
+	perms := map[string]bool{}
+	for _, p := range doc.Permissions {
+		if p.Name == "" {
+			return errors.New("policy: permission without name")
+		}
+		if perms[p.Name] {
+			return fmt.Errorf("policy: duplicate permission %q", p.Name)
+		}
+		perms[p.Name] = true
+	}
+	roles := map[string]bool{}
+	for _, r := range doc.Roles {
+		if r.Name == "" {
+			return errors.New("policy: role without name")
+		}
+		if roles[r.Name] {
+			return fmt.Errorf("policy: duplicate role %q", r.Name)
+		}
+		roles[r.Name] = true
+		for _, p := range r.Permissions {
+			if !perms[p] {
+				return fmt.Errorf("policy: role %q references unknown permission %q", r.Name, p)
+			}
+		}
+	}
+	users := map[string]bool{}
+	for _, u := range doc.Users {
+		if u.UserID == "" {
+			return errors.New("policy: user without user_id")
+		}
+		if users[u.UserID] {
+			return fmt.Errorf("policy: duplicate user %q", u.UserID)
+		}
+		users[u.UserID] = true
+		for _, r := range u.Roles {
+			if !roles[r] {
+				return fmt.Errorf("policy: user %q references unknown role %q", u.UserID, r)
+			}
+		}
+	}
+	return nil
+}
+
+// Export returns the Document of all permissions, roles and users of rbac.
+// References to permissions or roles that no longer exist, such as those left
+// behind by deleting through the stores directly, are left out of the
+// Document and listed in its Warnings.
+func Export(rbac *srbac.Rbac) (*Document, error) {
+	g, err := load(rbac)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &Document{Permissions: []Permission{}, Roles: []Role{}, Users: []User{}}
+	for _, p := range g.perms {
+		doc.Permissions = append(doc.Permissions, Permission{Name: p.Name, Description: p.Description})
+	}
+	for _, r := range g.roles {
+		ids, missing := splitIDs(r.Permissions, g.permByID)
+		for _, id := range missing {
+			doc.Warnings = append(doc.Warnings, fmt.Sprintf("role %q references missing permission %d", r.Name, id))
+		}
+		doc.Roles = append(doc.Roles, Role{Name: r.Name, Description: r.Description, Permissions: g.permNames(ids)})
+	}
+	for _, u := range g.users {
+		ids, missing := splitIDs(u.Roles, g.roleByID)
+		for _, id := range missing {
+			doc.Warnings = append(doc.Warnings, fmt.Sprintf("user %q references missing role %d", u.UserID, id))
+		}
+		doc.Users = append(doc.Users, User{UserID: u.UserID, Roles: g.roleNames(ids)})
+	}
+	sort.Slice(doc.Permissions, func(i, j int) bool { return doc.Permissions[i].Name < doc.Permissions[j].Name })
+	sort.Slice(doc.Roles, func(i, j int) bool { return doc.Roles[i].Name < doc.Roles[j].Name })
+	sort.Slice(doc.Users, func(i, j int) bool { return doc.Users[i].UserID < doc.Users[j].UserID })
+	sort.Strings(doc.Warnings)
+	return doc, nil
+}
+
+// graph is the current content of an Rbac, indexed by id and name.
+type graph struct {
+	perms      []models.Permission
+	roles      []models.Role
+	users      []models.User
+	permByID   map[int64]models.Permission
+	roleByID   map[int64]models.Role
+	permByName map[string]models.Permission
+	roleByName map[string]models.Role
+	userByUser map[string]models.User
+}
+
+func load(rbac *srbac.Rbac) (*graph, error) {
+	g := &graph{
+		permByID: map[int64]models.Permission{}, roleByID: map[int64]models.Role{},
+		permByName: map[string]models.Permission{}, roleByName: map[string]models.Role{},
+		userByUser: map[string]models.User{},
+	}
+	var err error
+	g.perms, err = rbac.PermissionStore.FindWhere()
+	if err != nil {
+		return nil, fmt.Errorf("policy: fail to load permissions: %w", err)
+	}
+	g.roles, err = rbac.RoleStore.FindWhere()
+	if err != nil {
+		return nil, fmt.Errorf("policy: fail to load roles: %w", err)
+	}
+	g.users, err = rbac.UserStore.FindWhere()
+	if err != nil {
+		return nil, fmt.Errorf("policy: fail to load users: %w", err)
+	}
+	for _, p := range g.perms {
+		if _, ok := g.permByName[p.Name]; ok {
+			return nil, fmt.Errorf("policy: several permissions are named %q", p.Name)
+		}
+		g.permByID[p.Id], g.permByName[p.Name] = p, p
+	}
+	for _, r := range g.roles {
+		if _, ok := g.roleByName[r.Name]; ok {
+			return nil, fmt.Errorf("policy: several roles are named %q", r.Name)
+		}
+		g.roleByID[r.Id], g.roleByName[r.Name] = r, r
+	}
+	for _, u := range g.users {
+		g.userByUser[u.UserID] = u
+	}
+	return g, nil
+}
+
+// permNames returns the sorted names of the permissions with ids, naming
+// missing permissions #id.
+func (g *graph) permNames(ids []int64) []string {
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if p, ok := g.permByID[id]; ok {
+			names = append(names, p.Name)
+		} else {
+			names = append(names, fmt.Sprintf("#%d", id))
+		}
+	}
+	return sortedUnique(names)
+}
+
+// roleNames returns the sorted names of the roles with ids, naming missing
+// roles #id.
+func (g *graph) roleNames(ids []int64) []string {
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if r, ok := g.roleByID[id]; ok {
+			names = append(names, r.Name)
+		} else {
+			names = append(names, fmt.Sprintf("#%d", id))
+		}
+	}
+	return sortedUnique(names)
+}
+
+// splitIDs splits ids into those present in byID and those missing from it.
+func splitIDs[V any](ids []int64, byID map[int64]V) (known, missing []int64) {
+	for _, id := range ids {
+		if _, ok := byID[id]; ok {
+			known = append(known, id)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	return known, missing
+}
+
+func sortedUnique(names []string) []string {
+	sort.Strings(names)
+	out := names[:0]
+	for i, n := range names {
+		if i == 0 || n != names[i-1] {
+			out = append(out, n)
+		}
+	}
+	return out
+}
@@ -0,0 +1,276 @@
+package policy
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yinloo-ola/srbac"
+	"github.com/yinloo-ola/srbac/helper"
+	"github.com/yinloo-ola/srbac/models"
+	sqlitestore "github.com/yinloo-ola/srbac/store/sqlite-store"
+)
+
+func newTestRbac(t *testing.T) *srbac.Rbac {
+	db, err := sqlitestore.Open(t.Name(), sqlitestore.WithInMemory())
+	helper.PanicErr(err)
+	t.Cleanup(func() { _ = db.Close() })
+	permissionStore, err := sqlitestore.NewStoreFromDB[models.Permission](db)
+	helper.PanicErr(err)
+	roleStore, err := sqlitestore.NewStoreFromDB[models.Role](db)
+	helper.PanicErr(err)
+	userStore, err := sqlitestore.NewStoreFromDB[models.User](db)
+	helper.PanicErr(err)
+	return srbac.NewRbac(permissionStore, roleStore, userStore)
+}
+
+const testPolicy = `permissions:
+  - name: reports:read
+    description: Read reports
+  - name: reports:write
+roles:
+  - name: analyst
+    permissions: [reports:read]
+  - name: editor
+    permissions: [reports:write, reports:read]
+users:
+  - user_id: bob
+    roles: [analyst]
+  - user_id: alice
+    roles: [editor, analyst]
+`
+
+// exported is testPolicy as written by Export.
+const exported = `permissions:
+  - name: reports:read
+    description: Read reports
+  - name: reports:write
+roles:
+  - name: analyst
+    permissions:
+      - reports:read
+  - name: editor
+    permissions:
+      - reports:read
+      - reports:write
+users:
+  - user_id: alice
+    roles:
+      - analyst
+      - editor
+  - user_id: bob
+    roles:
+      - analyst
+`
+
+func export(t *testing.T, rbac *srbac.Rbac, format Format) string {
+	doc, err := Export(rbac)
+	assert.NoError(t, err)
+	var buf bytes.Buffer
+	assert.NoError(t, Encode(&buf, doc, format))
+	return buf.String()
+}
+
+func TestReconcile(t *testing.T) {
+	rbac := newTestRbac(t)
+
+	doc, err := Decode(strings.NewReader(testPolicy), YAML)
+	assert.NoError(t, err)
+	diff, err := Reconcile(rbac, doc, Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, `+ permission reports:read
++ permission reports:write
++ role analyst
++ role editor
++ user bob
++ user alice
+`, diff.String())
+	assert.Equal(t, exported, export(t, rbac, YAML))
+
+	diff, err = Reconcile(rbac, doc, Options{Prune: true})
+	assert.NoError(t, err)
+	assert.Empty(t, diff)
+
+	// users managed outside the policy are kept unless pruning
+	_, err = rbac.UserStore.Insert(models.User{UserID: "carol", Roles: []int64{1}})
+	assert.NoError(t, err)
+
+	changed := `permissions:
+  - name: reports:read
+    description: Read all reports
+roles:
+  - name: analyst
+    permissions: [reports:read]
+users:
+  - user_id: alice
+    roles: [analyst]
+`
+	doc, err = Decode(strings.NewReader(changed), YAML)
+	assert.NoError(t, err)
+	want := `~ permission reports:read (description: "Read reports" -> "Read all reports")
+~ user alice (roles: [analyst editor] -> [analyst])
+- user bob
+- user carol
+- role editor
+- permission reports:write
+`
+	diff, err = Reconcile(rbac, doc, Options{Prune: true, DryRun: true})
+	assert.NoError(t, err)
+	assert.Equal(t, want, diff.String())
+	assert.Contains(t, export(t, rbac, YAML), "editor")
+
+	diff, err = Reconcile(rbac, doc, Options{Prune: true})
+	assert.NoError(t, err)
+	assert.Equal(t, want, diff.String())
+	assert.Equal(t, `permissions:
+  - name: reports:read
+    description: Read all reports
+roles:
+  - name: analyst
+    permissions:
+      - reports:read
+users:
+  - user_id: alice
+    roles:
+      - analyst
+`, export(t, rbac, YAML))
+
+	allowed, err := rbac.HasPermission("alice", 1)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+}
+
+func TestJSON(t *testing.T) {
+	rbac := newTestRbac(t)
+	doc, err := Decode(strings.NewReader(testPolicy), YAML)
+	assert.NoError(t, err)
+	_, err = Reconcile(rbac, doc, Options{})
+	assert.NoError(t, err)
+
+	out := export(t, rbac, JSON)
+	assert.Contains(t, out, `"user_id": "alice"`)
+	fromJSON, err := Decode(strings.NewReader(out), JSON)
+	assert.NoError(t, err)
+	fromYAML, err := Decode(strings.NewReader(exported), YAML)
+	assert.NoError(t, err)
+	assert.Equal(t, fromYAML, fromJSON)
+
+	assert.Equal(t, JSON, FormatOf("policy.JSON"))
+	assert.Equal(t, YAML, FormatOf("policy.yml"))
+}
+
+func TestExportDangling(t *testing.T) {
+	rbac := newTestRbac(t)
+	doc, err := Decode(strings.NewReader(testPolicy), YAML)
+	assert.NoError(t, err)
+	_, err = Reconcile(rbac, doc, Options{})
+	assert.NoError(t, err)
+
+	// deleting through the stores leaves the references behind
+	perm, err := rbac.PermissionID("reports:write")
+	assert.NoError(t, err)
+	assert.NoError(t, rbac.PermissionStore.DeleteMulti([]int64{perm}))
+	role, err := rbac.RoleID("analyst")
+	assert.NoError(t, err)
+	assert.NoError(t, rbac.RoleStore.DeleteMulti([]int64{role}))
+
+	doc, err = Export(rbac)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		fmt.Sprintf("role %q references missing permission %d", "editor", perm),
+		fmt.Sprintf("user %q references missing role %d", "alice", role),
+		fmt.Sprintf("user %q references missing role %d", "bob", role),
+	}, doc.Warnings)
+	var buf bytes.Buffer
+	assert.NoError(t, Encode(&buf, doc, YAML))
+	assert.Equal(t, `permissions:
+  - name: reports:read
+    description: Read reports
+roles:
+  - name: editor
+    permissions:
+      - reports:read
+users:
+  - user_id: alice
+    roles:
+      - editor
+  - user_id: bob
+    roles: []
+`, buf.String())
+}
+
+func TestValidate(t *testing.T) {
+	tests := map[string]string{
+		"unknown permission": "roles:\n  - name: a\n    permissions: [nope]\n",
+		"unknown role":       "users:\n  - user_id: a\n    roles: [nope]\n",
+		"duplicate role":     "roles:\n  - name: a\n  - name: a\n",
+		"missing name":       "permissions:\n  - description: x\n",
+		"unknown field":      "permissions:\n  - name: a\n    desc: x\n",
+	}
+	for name, doc := range tests {
+		_, err := Decode(strings.NewReader(doc), YAML)
+		assert.Error(t, err, name)
+	}
+
+	doc, err := Decode(strings.NewReader(""), YAML)
+	assert.NoError(t, err)
+	assert.Empty(t, doc.Roles)
+}
+
+const testCasbin = `# reports policy
+p, analyst, reports, read
+p, editor, reports, write
+p, editor, reports, read, allow
+g, editor, analyst
+g, alice, editor
+g, bob, analyst
+`
+
+func TestCasbin(t *testing.T) {
+	doc, err := Decode(strings.NewReader(testCasbin), Casbin)
+	assert.NoError(t, err)
+	assert.Equal(t, &Document{
+		Permissions: []Permission{{Name: "reports:read"}, {Name: "reports:write"}},
+		Roles: []Role{
+			{Name: "analyst", Permissions: []string{"reports:read"}},
+			{Name: "editor", Permissions: []string{"reports:read", "reports:write"}},
+		},
+		// alice inherits analyst through editor
+		Users: []User{{UserID: "alice", Roles: []string{"analyst", "editor"}}, {UserID: "bob", Roles: []string{"analyst"}}},
+	}, doc)
+
+	rbac := newTestRbac(t)
+	_, err = Reconcile(rbac, doc, Options{})
+	assert.NoError(t, err)
+	// Casbin has no descriptions
+	assert.Equal(t, strings.Replace(exported, "    description: Read reports\n", "", 1), export(t, rbac, YAML))
+	assert.Equal(t, `p,analyst,reports,read
+p,editor,reports,read
+p,editor,reports,write
+g,alice,analyst
+g,alice,editor
+g,bob,analyst
+`, export(t, rbac, Casbin))
+	assert.Equal(t, Casbin, FormatOf("policy.csv"))
+
+	tests := map[string]string{
+		"deny effect":    "p, analyst, reports, read, deny\n",
+		"missing act":    "p, analyst, reports\n",
+		"domain":         "g, alice, analyst, tenant1\n",
+		"unknown type":   "p2, analyst, reports, read\n",
+		"unquoted comma": "p, analyst, \"reports, read\n",
+	}
+	for name, policy := range tests {
+		_, err := Decode(strings.NewReader(policy), Casbin)
+		assert.Error(t, err, name)
+	}
+
+	_, err = Decode(strings.NewReader("p, alice, data1, read\ng, alice, admin\n"), Casbin)
+	assert.EqualError(t, err, `casbin policy line 2: "alice" is given roles and permissions directly, grant its permissions through a role`)
+
+	var buf bytes.Buffer
+	err = Encode(&buf, &Document{Roles: []Role{{Name: "a", Permissions: []string{"noact"}}}}, Casbin)
+	assert.ErrorContains(t, err, "obj:act")
+}
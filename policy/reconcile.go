@@ -0,0 +1,272 @@
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/yinloo-ola/srbac"
+	"github.com/yinloo-ola/srbac/models"
+)
+
+// Action is the kind of a Change.
+type Action string
+
+const (
+	Create Action = "create"
+	Update Action = "update"
+	Delete Action = "delete"
+)
+
+// Change is a change made by Reconcile, or that it would make in a dry run.
+type Change struct {
+	Action Action `json:"action"`
+	// Kind is permission, role or user.
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Details describes the updated fields.
+	Details []string `json:"details,omitempty"`
+}
+
+func (c Change) String() string {
+	sign := "~"
+	switch c.Action {
+	case Create:
+		sign = "+"
+	case Delete:
+		sign = "-"
+	}
+	s := fmt.Sprintf("%s %s %s", sign, c.Kind, c.Name)
+	if len(c.Details) > 0 {
+		s += " (" + strings.Join(c.Details, "; ") + ")"
+	}
+	return s
+}
+
+// Diff is the list of changes of a reconciliation, in the order they are made.
+type Diff []Change
+
+// String returns the changes one per line, "+" for created, "~" for updated
+// and "-" for deleted rows.
+func (d Diff) String() string {
+	var b strings.Builder
+	for _, c := range d {
+		b.WriteString(c.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// Options configures Reconcile.
+type Options struct {
+	// Prune deletes the permissions, roles and users missing from the document.
+	// Without it they are left untouched.
+	Prune bool
+	// DryRun only computes the changes without making them.
+	DryRun bool
+}
+
+// Reconcile creates and updates the permissions, roles and users of rbac to
+// match doc, matching them by name and user id, and returns the changes made.
+// The changes are not made atomically: when Reconcile fails part way, running
+// it again completes the reconciliation.
+func Reconcile(rbac *srbac.Rbac, doc *Document, opts Options) (Diff, error) {
+	err := doc.Validate()
+	if err != nil {
+		return nil, err
+	}
+	g, err := load(rbac)
+	if err != nil {
+		return nil, err
+	}
+	apply := !opts.DryRun
+	diff := Diff{}
+
+	for _, p := range doc.Permissions {
+		cur, ok := g.permByName[p.Name]
+		if !ok {
+			diff = append(diff, Change{Action: Create, Kind: "permission", Name: p.Name})
+			if apply {
+				cur = models.Permission{Name: p.Name, Description: p.Description}
+				cur.Id, err = rbac.PermissionStore.Insert(cur)
+				if err != nil {
+					return diff, fmt.Errorf("policy: fail to create permission %q: %w", p.Name, err)
+				}
+				g.permByName[p.Name] = cur
+			}
+			continue
+		}
+		if cur.Description == p.Description {
+			continue
+		}
+		diff = append(diff, Change{Action: Update, Kind: "permission", Name: p.Name,
+			Details: []string{fmt.Sprintf("description: %q -> %q", cur.Description, p.Description)}})
+		if apply {
+			cur.Description = p.Description
+			err = rbac.PermissionStore.UpdateFields(cur.Id, cur, "description")
+			if err != nil {
+				return diff, fmt.Errorf("policy: fail to update permission %q: %w", p.Name, err)
+			}
+		}
+	}
+
+	for _, r := range doc.Roles {
+		want := sortedUnique(append([]string(nil), r.Permissions...))
+		cur, ok := g.roleByName[r.Name]
+		if !ok {
+			diff = append(diff, Change{Action: Create, Kind: "role", Name: r.Name})
+			if apply {
+				cur = models.Role{Name: r.Name, Description: r.Description, Permissions: g.permIDs(want)}
+				cur.Id, err = rbac.RoleStore.Insert(cur)
+				if err != nil {
+					return diff, fmt.Errorf("policy: fail to create role %q: %w", r.Name, err)
+				}
+				g.roleByName[r.Name] = cur
+			}
+			continue
+		}
+		var details []string
+		if cur.Description != r.Description {
+			details = append(details, fmt.Sprintf("description: %q -> %q", cur.Description, r.Description))
+		}
+		if have := g.permNames(cur.Permissions); !equalNames(have, want) {
+			details = append(details, fmt.Sprintf("permissions: %v -> %v", have, want))
+		}
+		if len(details) == 0 {
+			continue
+		}
+		diff = append(diff, Change{Action: Update, Kind: "role", Name: r.Name, Details: details})
+		if apply {
+			cur.Description, cur.Permissions = r.Description, g.permIDs(want)
+			err = rbac.RoleStore.Update(cur.Id, cur)
+			if err != nil {
+				return diff, fmt.Errorf("policy: fail to update role %q: %w", r.Name, err)
+			}
+		}
+	}
+
+	for _, u := range doc.Users {
+		want := sortedUnique(append([]string(nil), u.Roles...))
+		cur, ok := g.userByUser[u.UserID]
+		if !ok {
+			diff = append(diff, Change{Action: Create, Kind: "user", Name: u.UserID})
+			if apply {
+				_, err = rbac.UserStore.Insert(models.User{UserID: u.UserID, Roles: g.roleIDs(want)})
+				if err != nil {
+					return diff, fmt.Errorf("policy: fail to create user %q: %w", u.UserID, err)
+				}
+			}
+			continue
+		}
+		have := g.roleNames(cur.Roles)
+		if equalNames(have, want) {
+			continue
+		}
+		diff = append(diff, Change{Action: Update, Kind: "user", Name: u.UserID,
+			Details: []string{fmt.Sprintf("roles: %v -> %v", have, want)}})
+		if apply {
+			cur.Roles = g.roleIDs(want)
+			err = rbac.UserStore.UpdateFields(cur.Id, cur, "roles", "version")
+			if err != nil {
+				return diff, fmt.Errorf("policy: fail to update user %q: %w", u.UserID, err)
+			}
+		}
+	}
+
+	if !opts.Prune {
+		return diff, nil
+	}
+	return prune(rbac, doc, g, diff, apply)
+}
+
+// prune deletes the users, roles and permissions of g missing from doc.
+func prune(rbac *srbac.Rbac, doc *Document, g *graph, diff Diff, apply bool) (Diff, error) {
+	keep := map[string]bool{}
+	for _, u := range doc.Users {
+		keep[u.UserID] = true
+	}
+	var ids []int64
+	for _, u := range sortedBy(g.users, func(u models.User) string { return u.UserID }) {
+		if !keep[u.UserID] {
+			diff = append(diff, Change{Action: Delete, Kind: "user", Name: u.UserID})
+			ids = append(ids, u.Id)
+		}
+	}
+	if apply && len(ids) > 0 {
+		err := rbac.UserStore.DeleteMulti(ids)
+		if err != nil {
+			return diff, fmt.Errorf("policy: fail to delete users: %w", err)
+		}
+	}
+
+	keep, ids = map[string]bool{}, nil
+	for _, r := range doc.Roles {
+		keep[r.Name] = true
+	}
+	for _, r := range sortedBy(g.roles, func(r models.Role) string { return r.Name }) {
+		if !keep[r.Name] {
+			diff = append(diff, Change{Action: Delete, Kind: "role", Name: r.Name})
+			ids = append(ids, r.Id)
+		}
+	}
+	if apply && len(ids) > 0 {
+		err := rbac.RoleStore.DeleteMulti(ids)
+		if err != nil {
+			return diff, fmt.Errorf("policy: fail to delete roles: %w", err)
+		}
+	}
+
+	keep, ids = map[string]bool{}, nil
+	for _, p := range doc.Permissions {
+		keep[p.Name] = true
+	}
+	for _, p := range sortedBy(g.perms, func(p models.Permission) string { return p.Name }) {
+		if !keep[p.Name] {
+			diff = append(diff, Change{Action: Delete, Kind: "permission", Name: p.Name})
+			ids = append(ids, p.Id)
+		}
+	}
+	if apply && len(ids) > 0 {
+		err := rbac.PermissionStore.DeleteMulti(ids)
+		if err != nil {
+			return diff, fmt.Errorf("policy: fail to delete permissions: %w", err)
+		}
+	}
+	return diff, nil
+}
+
+// permIDs returns the ids of the permissions with names, all of which exist.
+func (g *graph) permIDs(names []string) []int64 {
+	ids := make([]int64, 0, len(names))
+	for _, n := range names {
+		ids = append(ids, g.permByName[n].Id)
+	}
+	return ids
+}
+
+// roleIDs returns the ids of the roles with names, all of which exist.
+func (g *graph) roleIDs(names []string) []int64 {
+	ids := make([]int64, 0, len(names))
+	for _, n := range names {
+		ids = append(ids, g.roleByName[n].Id)
+	}
+	return ids
+}
+
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedBy[T any](s []T, key func(T) string) []T {
+	out := append([]T(nil), s...)
+	sort.SliceStable(out, func(i, j int) bool { return key(out[i]) < key(out[j]) })
+	return out
+}
@@ -1,16 +1,42 @@
 package srbac
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"sync"
 
 	"github.com/yinloo-ola/srbac/models"
 	"github.com/yinloo-ola/srbac/store"
 )
 
+// ErrUserNotFound is returned when no user has the user id. It matches
+// store.ErrNotFound with errors.Is.
+var ErrUserNotFound error = fmt.Errorf("user %w", store.ErrNotFound)
+
+// ErrAmbiguousUser is returned when more than one user has the user id.
+var ErrAmbiguousUser error = errors.New("multiple users matched")
+
+// ErrRoleMissing is returned when a user references a role that was never
+// stored. A soft deleted role is not missing, it grants nothing.
+var ErrRoleMissing error = errors.New("role missing")
+
+// ErrInUse is returned when deleting a permission granted by a role or a role
+// held by a user.
+var ErrInUse error = errors.New("record in use")
+
 type Rbac struct {
 	PermissionStore store.Store[models.Permission, *models.Permission]
 	RoleStore       store.Store[models.Role, *models.Role]
 	UserStore       store.Store[models.User, *models.User]
+
+	namesOnce       sync.Once
+	permissionNames *nameCache
+	roleNames       *nameCache
+
+	// refMu serialises the writes adding and removing references between
+	// permissions, roles and users; see WriteReferences.
+	refMu sync.Mutex
 }
 
 func NewRbac(permissionStore store.Store[
@@ -26,59 +52,483 @@ func NewRbac(permissionStore store.Store[
 }
 
 func (rbac *Rbac) HasPermission(userID string, permissionID int64) (bool, error) {
+	roles, err := rbac.userRoles(userID)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range roles {
+		for _, p := range r.Permissions {
+			if p == permissionID {
+				live, err := rbac.livePermissions([]int64{permissionID})
+				return live[permissionID], err
+			}
+		}
+	}
+	return false, nil
+}
+
+// CheckMany reports for each of permissionIDs whether userID has it. The user
+// and its roles are loaded once, making it cheaper than calling HasPermission
+// for every permission.
+func (rbac *Rbac) CheckMany(userID string, permissionIDs []int64) (map[int64]bool, error) {
+	roles, err := rbac.userRoles(userID)
+	if err != nil {
+		return nil, err
+	}
+	granted := make(map[int64]bool)
+	for _, r := range roles {
+		for _, p := range r.Permissions {
+			granted[p] = true
+		}
+	}
+	var asked []int64
+	for _, p := range permissionIDs {
+		if granted[p] {
+			asked = append(asked, p)
+		}
+	}
+	live, err := rbac.livePermissions(asked)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[int64]bool, len(permissionIDs))
+	for _, p := range permissionIDs {
+		result[p] = live[p]
+	}
+	return result, nil
+}
+
+// FilterUsersWithPermission returns the users of userIDs that have
+// permissionID, in the order of userIDs. Unknown users are left out. All users
+// and their roles are loaded in one query each.
+func (rbac *Rbac) FilterUsersWithPermission(userIDs []string, permissionID int64) ([]string, error) {
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+	vals := make([]any, 0, len(userIDs))
+	for _, u := range userIDs {
+		vals = append(vals, u)
+	}
 	users, err := rbac.UserStore.FindWhere(&store.WhereCond{
-		Field: "user_id", Val: userID, Op: store.OpEqual,
+		Field: "user_id", Val: vals, Op: store.OpIn,
 	})
 	if err != nil {
-		return false, fmt.Errorf("rbac.UserStore.FindField failed: %w", err)
-	}
-	if len(users) != 1 {
-		return false, store.ErrNotFound
+		return nil, fmt.Errorf("rbac.UserStore.FindField failed: %w", err)
 	}
 
-	roles, err := rbac.RoleStore.GetMulti(users[0].Roles)
+	roleIDs := make([]int64, 0, len(users))
+	seen := make(map[int64]bool)
+	found := make(map[string]bool, len(users))
+	for _, u := range users {
+		if found[u.UserID] {
+			return nil, fmt.Errorf("%w: %q", ErrAmbiguousUser, u.UserID)
+		}
+		found[u.UserID] = true
+		for _, r := range u.Roles {
+			if !seen[r] {
+				seen[r] = true
+				roleIDs = append(roleIDs, r)
+			}
+		}
+	}
+	roles, err := rbac.RoleStore.GetMulti(roleIDs)
 	if err != nil {
-		return false, fmt.Errorf("rbac.RoleStore.GetMulti failed: %w", err)
+		return nil, fmt.Errorf("rbac.RoleStore.GetMulti failed: %w", err)
+	}
+	missing, err := rbac.unknownRoles(roleIDs, roles)
+	if err != nil {
+		return nil, err
 	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: %v", ErrRoleMissing, missing)
+	}
+	granting := make(map[int64]bool)
 	for _, r := range roles {
 		for _, p := range r.Permissions {
 			if p == permissionID {
-				return true, nil
+				granting[r.Id] = true
+				break
 			}
 		}
 	}
-	return false, nil
+	if len(granting) > 0 {
+		live, err := rbac.livePermissions([]int64{permissionID})
+		if err != nil {
+			return nil, err
+		}
+		if !live[permissionID] {
+			return []string{}, nil
+		}
+	}
+
+	allowed := make(map[string]bool, len(users))
+	for _, u := range users {
+		for _, r := range u.Roles {
+			if granting[r] {
+				allowed[u.UserID] = true
+				break
+			}
+		}
+	}
+	result := make([]string, 0, len(allowed))
+	for _, u := range userIDs {
+		if allowed[u] {
+			result = append(result, u)
+			// report each user once even if listed twice
+			delete(allowed, u)
+		}
+	}
+	return result, nil
 }
 
+// GetUserPermissions returns the permissions granted to userID by any of its
+// roles, each once and ordered by id.
 func (rbac *Rbac) GetUserPermissions(userID string) ([]models.Permission, error) {
+	roles, err := rbac.userRoles(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissionIDs := make([]int64, 0, len(roles)*3)
+	seen := make(map[int64]bool)
+	for _, r := range roles {
+		for _, p := range r.Permissions {
+			if !seen[p] {
+				seen[p] = true
+				permissionIDs = append(permissionIDs, p)
+			}
+		}
+	}
+
+	permissions, err := rbac.PermissionStore.GetMulti(permissionIDs)
+	if err != nil {
+		return nil, fmt.Errorf("rbac.PermissionStore.GetMulti failed: %w", err)
+	}
+	sort.Slice(permissions, func(i, j int) bool { return permissions[i].Id < permissions[j].Id })
+	return permissions, nil
+}
+
+// RoleRef identifies a role.
+type RoleRef struct {
+	Id   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// EffectivePermission is a permission of a user along with the roles of the
+// user granting it.
+type EffectivePermission struct {
+	models.Permission
+	// GrantedBy is ordered by role id.
+	GrantedBy []RoleRef `json:"granted_by"`
+}
+
+// GetEffectivePermissions is GetUserPermissions with each permission annotated
+// with the roles granting it. Permissions are ordered by id.
+func (rbac *Rbac) GetEffectivePermissions(userID string) ([]EffectivePermission, error) {
+	roles, err := rbac.userRoles(userID)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Id < roles[j].Id })
+
+	grantedBy := make(map[int64][]RoleRef)
+	permissionIDs := make([]int64, 0, len(roles)*3)
+	for _, r := range roles {
+		for _, p := range r.Permissions {
+			refs := grantedBy[p]
+			if len(refs) > 0 && refs[len(refs)-1].Id == r.Id {
+				// the role lists the permission twice or the user lists the role twice
+				continue
+			}
+			if len(refs) == 0 {
+				permissionIDs = append(permissionIDs, p)
+			}
+			grantedBy[p] = append(refs, RoleRef{Id: r.Id, Name: r.Name})
+		}
+	}
+
+	permissions, err := rbac.PermissionStore.GetMulti(permissionIDs)
+	if err != nil {
+		return nil, fmt.Errorf("rbac.PermissionStore.GetMulti failed: %w", err)
+	}
+	sort.Slice(permissions, func(i, j int) bool { return permissions[i].Id < permissions[j].Id })
+
+	effective := make([]EffectivePermission, 0, len(permissions))
+	for _, p := range permissions {
+		effective = append(effective, EffectivePermission{Permission: p, GrantedBy: grantedBy[p.Id]})
+	}
+	return effective, nil
+}
+
+// livePermissions returns the ids among permissionIDs of the permissions that
+// are stored and not deleted. Roles keep the ids of deleted permissions, which
+// must not be granted.
+func (rbac *Rbac) livePermissions(permissionIDs []int64) (map[int64]bool, error) {
+	live := make(map[int64]bool, len(permissionIDs))
+	if len(permissionIDs) == 0 {
+		return live, nil
+	}
+	permissions, err := rbac.PermissionStore.FindFields([]string{"id"}, &store.WhereCond{
+		Field: "id", Val: idVals(permissionIDs), Op: store.OpIn,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("rbac.PermissionStore.FindFields failed: %w", err)
+	}
+	for _, p := range permissions {
+		live[p.Id] = true
+	}
+	return live, nil
+}
+
+// findUser returns the user with userID, or ErrUserNotFound or
+// ErrAmbiguousUser.
+func (rbac *Rbac) findUser(userID string) (models.User, error) {
 	users, err := rbac.UserStore.FindWhere(&store.WhereCond{
 		Field: "user_id", Val: userID, Op: store.OpEqual,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("rbac.UserStore.FindField failed: %w", err)
+		return models.User{}, fmt.Errorf("rbac.UserStore.FindField failed: %w", err)
+	}
+	switch len(users) {
+	case 0:
+		return models.User{}, fmt.Errorf("%w: %q", ErrUserNotFound, userID)
+	case 1:
+		return users[0], nil
+	default:
+		return models.User{}, fmt.Errorf("%w: %d users %q", ErrAmbiguousUser, len(users), userID)
 	}
-	if len(users) != 1 {
-		return nil, store.ErrNotFound
+}
+
+// userRoles returns the roles of userID, skipping the soft deleted ones, or
+// ErrRoleMissing if any of them was never stored.
+func (rbac *Rbac) userRoles(userID string) ([]models.Role, error) {
+	user, err := rbac.findUser(userID)
+	if err != nil {
+		return nil, err
 	}
 
-	roles, err := rbac.RoleStore.GetMulti(users[0].Roles)
+	roles, err := rbac.RoleStore.GetMulti(user.Roles)
 	if err != nil {
 		return nil, fmt.Errorf("rbac.RoleStore.GetMulti failed: %w", err)
 	}
+	missing, err := rbac.unknownRoles(user.Roles, roles)
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: user %q references %v", ErrRoleMissing, userID, missing)
+	}
+	return roles, nil
+}
 
-	permissionIDs := make([]int64, 0, len(roles)*3)
+// unknownRoles returns the ids of roleIDs absent from roles that were never
+// stored. A soft deleted role is not unknown, it just grants nothing.
+func (rbac *Rbac) unknownRoles(roleIDs []int64, roles []models.Role) ([]int64, error) {
+	missing := missingRoles(roleIDs, roles)
+	if len(missing) == 0 {
+		return nil, nil
+	}
+	deleted, err := rbac.deletedRoles(missing)
+	if err != nil {
+		return nil, err
+	}
+	unknown := missing[:0]
+	for _, id := range missing {
+		if !deleted[id] {
+			unknown = append(unknown, id)
+		}
+	}
+	return unknown, nil
+}
+
+// deletedRoles returns the ids among roleIDs of the roles that are soft
+// deleted.
+func (rbac *Rbac) deletedRoles(roleIDs []int64) (map[int64]bool, error) {
+	deleted := make(map[int64]bool)
+	if len(roleIDs) == 0 {
+		return deleted, nil
+	}
+	roles, err := rbac.RoleStore.FindDeleted(&store.WhereCond{
+		Field: "id", Val: idVals(roleIDs), Op: store.OpIn,
+	})
+	if errors.Is(err, store.ErrSoftDeleteUnsupported) {
+		return deleted, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("rbac.RoleStore.FindDeleted failed: %w", err)
+	}
 	for _, r := range roles {
-		permissionIDs = append(permissionIDs, r.Permissions...)
+		deleted[r.Id] = true
 	}
+	return deleted, nil
+}
 
-	permissions, err := rbac.PermissionStore.GetMulti(permissionIDs)
+// deletedPermissions returns the ids among permissionIDs of the permissions
+// that are soft deleted.
+func (rbac *Rbac) deletedPermissions(permissionIDs []int64) (map[int64]bool, error) {
+	deleted := make(map[int64]bool)
+	if len(permissionIDs) == 0 {
+		return deleted, nil
+	}
+	permissions, err := rbac.PermissionStore.FindDeleted(&store.WhereCond{
+		Field: "id", Val: idVals(permissionIDs), Op: store.OpIn,
+	})
+	if errors.Is(err, store.ErrSoftDeleteUnsupported) {
+		return deleted, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("rbac.PermissionStore.GetMulti failed: %w", err)
+		return nil, fmt.Errorf("rbac.PermissionStore.FindDeleted failed: %w", err)
 	}
-	return permissions, nil
+	for _, p := range permissions {
+		deleted[p.Id] = true
+	}
+	return deleted, nil
+}
+
+// idVals returns ids as the value of an OpIn condition.
+func idVals(ids []int64) []any {
+	vals := make([]any, 0, len(ids))
+	for _, id := range ids {
+		vals = append(vals, id)
+	}
+	return vals
+}
+
+// missingRoles returns the ids of roleIDs absent from roles.
+func missingRoles(roleIDs []int64, roles []models.Role) []int64 {
+	found := make(map[int64]bool, len(roles))
+	for _, r := range roles {
+		found[r.Id] = true
+	}
+	var missing []int64
+	for _, id := range roleIDs {
+		if !found[id] {
+			missing = append(missing, id)
+			found[id] = true
+		}
+	}
+	return missing
+}
+
+// WriteReferences runs fn while no other WriteReferences, UpdateUserRoles,
+// DeletePermissions or DeleteRoles call on rbac runs. Writes making a role
+// grant permissions or a user hold roles must check that those exist and
+// write them within fn, so that a delete cannot slip in between and leave
+// them referencing a deleted row. Writes through other Rbac values or
+// processes are not serialised.
+func (rbac *Rbac) WriteReferences(fn func() error) error {
+	rbac.refMu.Lock()
+	defer rbac.refMu.Unlock()
+	return fn()
+}
+
+// maxUpdateAttempts is how many times UpdateUserRoles reads the user again
+// after losing a race with another write.
+const maxUpdateAttempts = 5
+
+// UpdateUserRoles sets the roles of the user with id to update(roles), roles
+// being its current roles, and returns the updated user. The write is guarded
+// by the version of the user: when another write changed the user in the
+// meantime, the user is read again and update called again, so concurrent
+// updates are not lost. It returns ErrRoleMissing if update adds a role that
+// does not exist or is deleted.
+func (rbac *Rbac) UpdateUserRoles(id int64, update func(roles []int64) []int64) (models.User, error) {
+	rbac.refMu.Lock()
+	defer rbac.refMu.Unlock()
+
+	for attempt := 1; ; attempt++ {
+		user, err := rbac.UserStore.GetOne(id)
+		if err != nil {
+			return models.User{}, err
+		}
+		held := idSet(user.Roles)
+		user.Roles = update(append([]int64(nil), user.Roles...))
+		if user.Roles == nil {
+			user.Roles = []int64{}
+		}
+		var added []int64
+		for _, r := range user.Roles {
+			if !held[r] {
+				added = append(added, r)
+			}
+		}
+		roles, err := rbac.RoleStore.GetMulti(added)
+		if err != nil {
+			return models.User{}, fmt.Errorf("rbac.RoleStore.GetMulti failed: %w", err)
+		}
+		if missing := missingRoles(added, roles); len(missing) > 0 {
+			return models.User{}, fmt.Errorf("%w: %v", ErrRoleMissing, missing)
+		}
+
+		err = rbac.UserStore.UpdateFields(id, user, "roles", "version")
+		if errors.Is(err, store.ErrConflict) && attempt < maxUpdateAttempts {
+			continue
+		}
+		if err != nil {
+			return models.User{}, err
+		}
+		return rbac.UserStore.GetOne(id)
+	}
+}
+
+// DeletePermissions deletes the permissions with ids. It returns ErrInUse,
+// deleting nothing, while any role still grants one of them. The check and
+// the delete run under the lock of WriteReferences.
+func (rbac *Rbac) DeletePermissions(ids []int64) error {
+	rbac.refMu.Lock()
+	defer rbac.refMu.Unlock()
+
+	deleting := idSet(ids)
+	err := rbac.RoleStore.Iterate(func(r models.Role) error {
+		for _, p := range r.Permissions {
+			if deleting[p] {
+				return fmt.Errorf("%w: permission %d is granted by role %q", ErrInUse, p, r.Name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return rbac.PermissionStore.DeleteMulti(ids)
+}
+
+// DeleteRoles deletes the roles with ids. It returns ErrInUse, deleting
+// nothing, while any user still holds one of them. The check and the delete
+// run under the lock of WriteReferences.
+func (rbac *Rbac) DeleteRoles(ids []int64) error {
+	rbac.refMu.Lock()
+	defer rbac.refMu.Unlock()
+
+	deleting := idSet(ids)
+	err := rbac.UserStore.Iterate(func(u models.User) error {
+		for _, r := range u.Roles {
+			if deleting[r] {
+				return fmt.Errorf("%w: role %d is held by user %q", ErrInUse, r, u.UserID)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return rbac.RoleStore.DeleteMulti(ids)
+}
+
+func idSet(ids []int64) map[int64]bool {
+	set := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
 }
 
 func (rbac *Rbac) Close() error {
+	if rbac.permissionNames != nil {
+		rbac.permissionNames.close()
+		rbac.roleNames.close()
+	}
 	err1 := rbac.PermissionStore.Close()
 	err2 := rbac.RoleStore.Close()
 	err3 := rbac.UserStore.Close()
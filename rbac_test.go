@@ -10,6 +10,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/yinloo-ola/srbac/helper"
 	"github.com/yinloo-ola/srbac/models"
+	"github.com/yinloo-ola/srbac/store"
 	sqlitestore "github.com/yinloo-ola/srbac/store/sqlite-store"
 )
 
@@ -41,10 +42,12 @@ func TestParallelInsert(t *testing.T) {
 	}()
 	permsIn := make([]models.Permission, 0, 100)
 	permChan := make(chan models.Permission, 100)
+	collected := make(chan struct{})
 	go func(ch chan models.Permission) {
 		for p := range ch {
 			permsIn = append(permsIn, p)
 		}
+		close(collected)
 	}(permChan)
 
 	var wg sync.WaitGroup
@@ -65,11 +68,12 @@ func TestParallelInsert(t *testing.T) {
 	}
 	wg.Wait()
 	close(permChan)
+	<-collected
 
 	perms, err := rbac.PermissionStore.FindWhere()
 	helper.PanicErr(err)
 	assert.Len(perms, 100)
-	assert.ElementsMatch(permsIn, perms)
+	assert.ElementsMatch(permsIn, withoutTimestamps(t, perms))
 }
 
 func TestRbac_HasPermission(t *testing.T) {
@@ -283,7 +287,346 @@ func TestRbac_GetUserPermissions(t *testing.T) {
 
 		perms, err := rbac.GetUserPermissions(users[i].UserID)
 		helper.PanicErr(err)
-		assert.ElementsMatch(perms, permsIn[start:end])
+		assert.ElementsMatch(withoutTimestamps(t, perms), permsIn[start:end])
 	}
 
 }
+
+// withoutTimestamps checks that the store set the timestamps of perms and
+// clears them so perms can be compared with the values that were inserted.
+func withoutTimestamps(t *testing.T, perms []models.Permission) []models.Permission {
+	out := make([]models.Permission, 0, len(perms))
+	for _, p := range perms {
+		assert.False(t, p.CreatedAt.IsZero())
+		assert.False(t, p.UpdatedAt.IsZero())
+		p.CreatedAt = time.Time{}
+		p.UpdatedAt = time.Time{}
+		out = append(out, p)
+	}
+	return out
+}
+
+func TestRbac_HasPermissionByName(t *testing.T) {
+	assert := assert.New(t)
+	rbac := newTestRbac(t)
+
+	writeID, err := rbac.PermissionStore.Insert(models.Permission{Name: "orders:write"})
+	helper.PanicErr(err)
+	readID, err := rbac.PermissionStore.Insert(models.Permission{Name: "orders:read"})
+	helper.PanicErr(err)
+	roleID, err := rbac.RoleStore.Insert(models.Role{Name: "clerk", Permissions: []int64{writeID}})
+	helper.PanicErr(err)
+	_, err = rbac.UserStore.Insert(models.User{UserID: "alice", Roles: []int64{roleID}})
+	helper.PanicErr(err)
+
+	_, err = rbac.PermissionStore.Insert(models.Permission{Name: "orders:write"})
+	assert.ErrorIs(err, store.ErrDuplicate)
+	_, err = rbac.RoleStore.Insert(models.Role{Name: "clerk"})
+	assert.ErrorIs(err, store.ErrDuplicate)
+
+	id, err := rbac.PermissionID("orders:write")
+	assert.NoError(err)
+	assert.Equal(writeID, id)
+	id, err = rbac.RoleID("clerk")
+	assert.NoError(err)
+	assert.Equal(roleID, id)
+
+	has, err := rbac.HasPermissionByName("alice", "orders:write")
+	assert.NoError(err)
+	assert.True(has)
+	has, err = rbac.HasPermissionByName("alice", "orders:read")
+	assert.NoError(err)
+	assert.False(has)
+	_, err = rbac.HasPermissionByName("alice", "orders:delete")
+	assert.ErrorIs(err, store.ErrNotFound)
+
+	// renaming invalidates the cached ids as soon as it commits
+	err = rbac.PermissionStore.UpdateFields(writeID, models.Permission{Name: "orders:write-old"}, "name")
+	assert.NoError(err)
+	err = rbac.PermissionStore.UpdateFields(readID, models.Permission{Name: "orders:write"}, "name")
+	assert.NoError(err)
+	id, err = rbac.PermissionID("orders:write")
+	assert.NoError(err)
+	assert.Equal(readID, id)
+	has, err = rbac.HasPermissionByName("alice", "orders:write")
+	assert.NoError(err)
+	assert.False(has)
+
+	err = rbac.RoleStore.DeleteMulti([]int64{roleID})
+	assert.NoError(err)
+	_, err = rbac.RoleID("clerk")
+	assert.ErrorIs(err, store.ErrNotFound)
+
+	// writes through another handle on the database are seen on the next poll
+	id, err = rbac.PermissionID("orders:write-old")
+	assert.NoError(err)
+	assert.Equal(writeID, id)
+	other, err := sqlitestore.NewStore[models.Permission](t.Name(), sqlitestore.WithInMemory())
+	helper.PanicErr(err)
+	defer other.Close()
+	err = other.DeleteMulti([]int64{writeID})
+	assert.NoError(err)
+	id, err = rbac.PermissionID("orders:write-old")
+	assert.NoError(err)
+	assert.Equal(writeID, id)
+	rbac.permissionNames.polled = time.Time{}
+	_, err = rbac.PermissionID("orders:write-old")
+	assert.ErrorIs(err, store.ErrNotFound)
+	newID, err := other.Insert(models.Permission{Name: "orders:write-old"})
+	assert.NoError(err)
+	id, err = rbac.PermissionID("orders:write-old")
+	assert.NoError(err)
+	assert.Equal(newID, id)
+}
+
+func TestNameCache(t *testing.T) {
+	assert := assert.New(t)
+	rbac := newTestRbac(t)
+	ids, err := rbac.PermissionStore.InsertMulti([]models.Permission{{Name: "read"}, {Name: "write"}})
+	helper.PanicErr(err)
+
+	lookups := 0
+	c := newNameCache(rbac.PermissionStore, func(name string) ([]int64, error) {
+		lookups++
+		perms, err := rbac.PermissionStore.FindFields([]string{"id"}, nameCond(name))
+		if err != nil {
+			return nil, err
+		}
+		found := make([]int64, 0, len(perms))
+		for _, p := range perms {
+			found = append(found, p.Id)
+		}
+		return found, nil
+	})
+	defer c.close()
+
+	for i := 0; i < 3; i++ {
+		id, err := c.id("read")
+		assert.NoError(err)
+		assert.Equal(ids[0], id)
+	}
+	assert.Equal(1, lookups)
+
+	// a change to another row keeps the entry, a change to its row drops it
+	helper.PanicErr(rbac.PermissionStore.UpdateFields(ids[1], models.Permission{Description: "changed"}, "description"))
+	_, err = c.id("read")
+	assert.NoError(err)
+	assert.Equal(1, lookups)
+	helper.PanicErr(rbac.PermissionStore.UpdateFields(ids[0], models.Permission{Description: "changed"}, "description"))
+	_, err = c.id("read")
+	assert.NoError(err)
+	assert.Equal(2, lookups)
+}
+
+// newTestRbac returns an Rbac backed by an in-memory database named after
+// the test.
+func newTestRbac(t *testing.T) *Rbac {
+	permissionStore, err := sqlitestore.NewStore[models.Permission](t.Name(), sqlitestore.WithInMemory())
+	helper.PanicErr(err)
+	roleStore, err := sqlitestore.NewStore[models.Role](t.Name(), sqlitestore.WithInMemory())
+	helper.PanicErr(err)
+	userStore, err := sqlitestore.NewStore[models.User](t.Name(), sqlitestore.WithInMemory())
+	helper.PanicErr(err)
+	rbac := NewRbac(permissionStore, roleStore, userStore)
+	t.Cleanup(func() { helper.PanicErr(rbac.Close()) })
+	return rbac
+}
+
+func TestRbac_CheckMany(t *testing.T) {
+	assert := assert.New(t)
+	rbac := newTestRbac(t)
+
+	permIDs, err := rbac.PermissionStore.InsertMulti([]models.Permission{
+		{Name: "menu:orders"}, {Name: "menu:invoices"}, {Name: "menu:admin"},
+	})
+	helper.PanicErr(err)
+	roleIDs, err := rbac.RoleStore.InsertMulti([]models.Role{
+		{Name: "clerk", Permissions: []int64{permIDs[0]}},
+		{Name: "accountant", Permissions: []int64{permIDs[0], permIDs[1]}},
+	})
+	helper.PanicErr(err)
+	_, err = rbac.UserStore.InsertMulti([]models.User{
+		{UserID: "alice", Roles: []int64{roleIDs[0]}},
+		{UserID: "bob", Roles: []int64{roleIDs[0], roleIDs[1]}},
+		{UserID: "carol"},
+	})
+	helper.PanicErr(err)
+
+	checks, err := rbac.CheckMany("bob", permIDs)
+	assert.NoError(err)
+	assert.Equal(map[int64]bool{permIDs[0]: true, permIDs[1]: true, permIDs[2]: false}, checks)
+	checks, err = rbac.CheckMany("carol", permIDs[:1])
+	assert.NoError(err)
+	assert.Equal(map[int64]bool{permIDs[0]: false}, checks)
+	_, err = rbac.CheckMany("dave", permIDs)
+	assert.ErrorIs(err, store.ErrNotFound)
+
+	users, err := rbac.FilterUsersWithPermission([]string{"carol", "bob", "dave", "alice", "bob"}, permIDs[0])
+	assert.NoError(err)
+	assert.Equal([]string{"bob", "alice"}, users)
+	users, err = rbac.FilterUsersWithPermission([]string{"alice", "bob", "carol"}, permIDs[1])
+	assert.NoError(err)
+	assert.Equal([]string{"bob"}, users)
+	users, err = rbac.FilterUsersWithPermission(nil, permIDs[0])
+	assert.NoError(err)
+	assert.Empty(users)
+}
+
+func TestRbac_UpdateUserRoles(t *testing.T) {
+	assert := assert.New(t)
+	rbac := newTestRbac(t)
+	roleIDs, err := rbac.RoleStore.InsertMulti([]models.Role{{Name: "reader"}, {Name: "writer"}, {Name: "admin"}})
+	helper.PanicErr(err)
+	id, err := rbac.UserStore.Insert(models.User{UserID: "alice", Roles: []int64{roleIDs[0]}})
+	helper.PanicErr(err)
+
+	// another writer assigns writer while admin is being added
+	calls := 0
+	user, err := rbac.UpdateUserRoles(id, func(roles []int64) []int64 {
+		calls++
+		if calls == 1 {
+			other, err := rbac.UserStore.GetOne(id)
+			helper.PanicErr(err)
+			other.Roles = append(other.Roles, roleIDs[1])
+			helper.PanicErr(rbac.UserStore.UpdateFields(id, other, "roles", "version"))
+		}
+		return append(roles, roleIDs[2])
+	})
+	assert.NoError(err)
+	assert.Equal(2, calls)
+	assert.Equal(roleIDs, user.Roles)
+
+	_, err = rbac.UpdateUserRoles(id, func(roles []int64) []int64 { return append(roles, 99) })
+	assert.ErrorIs(err, ErrRoleMissing)
+	guestID, err := rbac.RoleStore.Insert(models.Role{Name: "guest"})
+	helper.PanicErr(err)
+	helper.PanicErr(rbac.RoleStore.DeleteMulti([]int64{guestID, roleIDs[2]}))
+	_, err = rbac.UpdateUserRoles(id, func(roles []int64) []int64 { return append(roles, guestID) })
+	assert.ErrorIs(err, ErrRoleMissing)
+	// roles already held may be deleted, they are not checked again
+	user, err = rbac.UpdateUserRoles(id, func(roles []int64) []int64 { return roles[1:] })
+	assert.NoError(err)
+	assert.Equal(roleIDs[1:], user.Roles)
+}
+
+func TestRbac_DeletedRole(t *testing.T) {
+	assert := assert.New(t)
+	rbac := newTestRbac(t)
+
+	permIDs, err := rbac.PermissionStore.InsertMulti([]models.Permission{{Name: "read"}, {Name: "write"}})
+	helper.PanicErr(err)
+	roleIDs, err := rbac.RoleStore.InsertMulti([]models.Role{
+		{Name: "reader", Permissions: []int64{permIDs[0]}},
+		{Name: "writer", Permissions: []int64{permIDs[1]}},
+	})
+	helper.PanicErr(err)
+	_, err = rbac.UserStore.InsertMulti([]models.User{
+		{UserID: "alice", Roles: []int64{roleIDs[0], roleIDs[1]}},
+		{UserID: "bob", Roles: []int64{roleIDs[1], 99}},
+	})
+	helper.PanicErr(err)
+
+	// deleted behind the back of Rbac, so alice still holds writer
+	helper.PanicErr(rbac.RoleStore.DeleteMulti([]int64{roleIDs[1]}))
+
+	ok, err := rbac.HasPermission("alice", permIDs[1])
+	assert.NoError(err)
+	assert.False(ok)
+	ok, err = rbac.HasPermission("alice", permIDs[0])
+	assert.NoError(err)
+	assert.True(ok)
+	users, err := rbac.FilterUsersWithPermission([]string{"alice"}, permIDs[1])
+	assert.NoError(err)
+	assert.Empty(users)
+
+	// role 99 was never stored
+	_, err = rbac.HasPermission("bob", permIDs[1])
+	assert.ErrorIs(err, ErrRoleMissing)
+	assert.ErrorContains(err, "[99]")
+	_, err = rbac.FilterUsersWithPermission([]string{"alice", "bob"}, permIDs[1])
+	assert.ErrorIs(err, ErrRoleMissing)
+
+	anomalies, err := rbac.CheckConsistency()
+	assert.NoError(err)
+	assert.Equal([]Anomaly{{Kind: AnomalyMissingRole, UserID: "bob", RoleID: 99}}, anomalies)
+}
+
+func TestRbac_DeletedPermission(t *testing.T) {
+	assert := assert.New(t)
+	rbac := newTestRbac(t)
+
+	permIDs, err := rbac.PermissionStore.InsertMulti([]models.Permission{{Name: "read"}, {Name: "write"}})
+	helper.PanicErr(err)
+	roleID, err := rbac.RoleStore.Insert(models.Role{Name: "editor", Permissions: permIDs})
+	helper.PanicErr(err)
+	_, err = rbac.UserStore.Insert(models.User{UserID: "alice", Roles: []int64{roleID}})
+	helper.PanicErr(err)
+
+	// deleted behind the back of Rbac, so editor still lists write
+	helper.PanicErr(rbac.PermissionStore.DeleteMulti([]int64{permIDs[1]}))
+
+	ok, err := rbac.HasPermission("alice", permIDs[1])
+	assert.NoError(err)
+	assert.False(ok)
+	ok, err = rbac.HasPermissionByName("alice", "write")
+	assert.ErrorIs(err, store.ErrNotFound)
+	assert.False(ok)
+	checks, err := rbac.CheckMany("alice", permIDs)
+	assert.NoError(err)
+	assert.Equal(map[int64]bool{permIDs[0]: true, permIDs[1]: false}, checks)
+	users, err := rbac.FilterUsersWithPermission([]string{"alice"}, permIDs[1])
+	assert.NoError(err)
+	assert.Empty(users)
+	perms, err := rbac.GetUserPermissions("alice")
+	assert.NoError(err)
+	assert.Len(perms, 1)
+	assert.Equal("read", perms[0].Name)
+	anomalies, err := rbac.CheckConsistency()
+	assert.NoError(err)
+	assert.Empty(anomalies)
+}
+
+func TestRbac_GetEffectivePermissions(t *testing.T) {
+	assert := assert.New(t)
+	rbac := newTestRbac(t)
+
+	permIDs, err := rbac.PermissionStore.InsertMulti([]models.Permission{
+		{Name: "orders:read"}, {Name: "orders:write"}, {Name: "invoices:read"},
+	})
+	helper.PanicErr(err)
+	roleIDs, err := rbac.RoleStore.InsertMulti([]models.Role{
+		{Name: "clerk", Permissions: []int64{permIDs[1], permIDs[0], permIDs[0]}},
+		{Name: "accountant", Permissions: []int64{permIDs[2], permIDs[0]}},
+	})
+	helper.PanicErr(err)
+	_, err = rbac.UserStore.Insert(models.User{UserID: "alice", Roles: []int64{roleIDs[1], roleIDs[0]}})
+	helper.PanicErr(err)
+
+	perms, err := rbac.GetUserPermissions("alice")
+	assert.NoError(err)
+	names := make([]string, 0, len(perms))
+	for _, p := range perms {
+		names = append(names, p.Name)
+	}
+	assert.Equal([]string{"orders:read", "orders:write", "invoices:read"}, names)
+
+	effective, err := rbac.GetEffectivePermissions("alice")
+	assert.NoError(err)
+	clerk := RoleRef{Id: roleIDs[0], Name: "clerk"}
+	accountant := RoleRef{Id: roleIDs[1], Name: "accountant"}
+	grants := make(map[string][]RoleRef, len(effective))
+	names = names[:0]
+	for _, p := range effective {
+		names = append(names, p.Name)
+		grants[p.Name] = p.GrantedBy
+	}
+	assert.Equal([]string{"orders:read", "orders:write", "invoices:read"}, names)
+	assert.Equal(map[string][]RoleRef{
+		"orders:read":   {clerk, accountant},
+		"orders:write":  {clerk},
+		"invoices:read": {accountant},
+	}, grants)
+
+	_, err = rbac.GetEffectivePermissions("bob")
+	assert.ErrorIs(err, store.ErrNotFound)
+}
@@ -0,0 +1,134 @@
+package sqlitestore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yinloo-ola/srbac/store"
+)
+
+// changeLogTable persists every committed write so that consumers in other
+// processes can catch up with ChangesSince. AUTOINCREMENT keeps seq monotonic
+// even after old entries are pruned. The table prefix of the DB is prepended.
+const changeLogTable = "srbac_changelog"
+
+func createChangeLog(db *sql.DB, changeLogTable string) error {
+	_, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT, tbl TEXT, op TEXT, ids TEXT, created_at DATETIME);
+		CREATE INDEX IF NOT EXISTS idx_%s_tbl ON %s (tbl, seq);`, changeLogTable, changeLogTable, changeLogTable))
+	return err
+}
+
+func recordChange(tx *sql.Tx, changeLogTable string, table string, op store.ChangeOp, ids []int64) (store.ChangeEvent, error) {
+	event := store.ChangeEvent{Table: table, Op: op, IDs: ids, At: time.Now().UTC()}
+	idsJSON, err := json.Marshal(ids)
+	if err != nil {
+		return event, err
+	}
+	err = tx.QueryRow(fmt.Sprintf("INSERT INTO %s (tbl, op, ids, created_at) VALUES (?, ?, ?, ?) RETURNING seq", changeLogTable),
+		table, string(op), string(idsJSON), event.At).Scan(&event.Seq)
+	return event, err
+}
+
+// changesSince returns the logged changes after seq in sequence order. An empty
+// table returns the changes of all tables.
+func changesSince(db *sql.DB, changeLogTable string, table string, seq int64) ([]store.ChangeEvent, error) {
+	query := fmt.Sprintf("SELECT seq, tbl, op, ids, created_at from %s where seq > ?", changeLogTable)
+	args := []any{seq}
+	if table != "" {
+		query += " and tbl = ?"
+		args = append(args, table)
+	}
+	rows, err := db.Query(query+" order by seq", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []store.ChangeEvent
+	for rows.Next() {
+		var event store.ChangeEvent
+		var op string
+		var ids []byte
+		err = rows.Scan(&event.Seq, &event.Table, &op, &ids, &event.At)
+		if err != nil {
+			return nil, err
+		}
+		event.Op = store.ChangeOp(op)
+		event.At = event.At.UTC()
+		err = json.Unmarshal(ids, &event.IDs)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// lastSeq returns the seq of the latest logged change of table, or of any
+// table if table is empty, or 0 if there is none.
+func lastSeq(db *sql.DB, changeLogTable string, table string) (int64, error) {
+	query := fmt.Sprintf("SELECT coalesce(max(seq), 0) from %s", changeLogTable)
+	var args []any
+	if table != "" {
+		query += " where tbl = ?"
+		args = append(args, table)
+	}
+	var seq int64
+	err := db.QueryRow(query, args...).Scan(&seq)
+	return seq, err
+}
+
+func pruneChanges(db *sql.DB, changeLogTable string, upTo int64) error {
+	_, err := db.Exec(fmt.Sprintf("DELETE from %s where seq <= ?", changeLogTable), upTo)
+	return err
+}
+
+// changeFeed fans out committed change events to in-process subscribers.
+type changeFeed struct {
+	mu   sync.Mutex
+	subs map[chan store.ChangeEvent]string
+}
+
+func newChangeFeed() *changeFeed {
+	return &changeFeed{subs: make(map[chan store.ChangeEvent]string)}
+}
+
+// subscribe registers a subscriber for the events of table, or of all tables if
+// table is empty. A subscriber that falls more than buffer events behind is
+// dropped and its channel closed.
+func (f *changeFeed) subscribe(table string, buffer int) (<-chan store.ChangeEvent, func()) {
+	ch := make(chan store.ChangeEvent, buffer)
+	f.mu.Lock()
+	f.subs[ch] = table
+	f.mu.Unlock()
+
+	cancel := func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if _, ok := f.subs[ch]; ok {
+			delete(f.subs, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
+
+func (f *changeFeed) publish(event store.ChangeEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch, table := range f.subs {
+		if table != "" && table != event.Table {
+			continue
+		}
+		select {
+		case ch <- event:
+		default:
+			delete(f.subs, ch)
+			close(ch)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package sqlitestore
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+
+	"github.com/yinloo-ola/srbac/store"
+)
+
+// DB is a SQLite database shared by the stores of several models. It owns the
+// single *sql.DB of the file, serialises the writes of all its stores with one
+// lock and publishes their changes on one feed. Stores are obtained with
+// NewStoreFromDB and the database is closed once with Close.
+type DB struct {
+	db        *sql.DB
+	readOnly  bool
+	inMemory  bool
+	prefix    string
+	changeLog string
+	feed      *changeFeed
+	lock      *sync.RWMutex
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Open opens the SQLite database at path. By default the database uses WAL
+// journaling with synchronous NORMAL; see Option for the settings available.
+func Open(path string, opts ...Option) (*DB, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	db, err := sql.Open("sqlite", o.dataSourceName(path))
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(o.maxOpenConns)
+	db.SetMaxIdleConns(o.maxIdleConns)
+	db.SetConnMaxLifetime(o.connMaxLifetime)
+
+	err = db.Ping()
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	if !validIdentifier(o.tablePrefix) {
+		_ = db.Close()
+		return nil, fmt.Errorf("invalid table prefix %q", o.tablePrefix)
+	}
+	changeLog := o.tablePrefix + changeLogTable
+	if !o.readOnly {
+		err = createChangeLog(db, changeLog)
+		if err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+	return &DB{
+		db: db, readOnly: o.readOnly, inMemory: o.inMemory, prefix: o.tablePrefix, changeLog: changeLog,
+		feed: newChangeFeed(), lock: &sync.RWMutex{},
+	}, nil
+}
+
+// NewStoreFromDB returns the store of T in db. All stores of a DB share its
+// connection pool and write lock; closing them does not close db.
+// Only the table options WithTableName and WithTablePrefix apply here,
+// connection options are set with Open.
+func NewStoreFromDB[T any, R store.Row[T]](db *DB, opts ...Option) (*SQliteStore[T, R], error) {
+	o := options{tablePrefix: db.prefix}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return newStore[T, R](db, o, rowMethods[T, R]())
+}
+
+// Subscribe returns a channel receiving the change events of all tables in db
+// after they are committed, and a function to unsubscribe. See SQliteStore.Subscribe.
+func (d *DB) Subscribe(buffer int) (<-chan store.ChangeEvent, func()) {
+	return d.feed.subscribe("", buffer)
+}
+
+// ChangesSince returns the persisted change events of all tables in db with a
+// sequence number greater than seq.
+func (d *DB) ChangesSince(seq int64) ([]store.ChangeEvent, error) {
+	events, err := changesSince(d.db, d.changeLog, "", seq)
+	if err != nil {
+		return nil, fmt.Errorf("ChangesSince failed: %w", err)
+	}
+	return events, nil
+}
+
+// LastSeq returns the sequence number of the latest persisted change event of
+// any table in db, or 0 if there is none.
+func (d *DB) LastSeq() (int64, error) {
+	seq, err := lastSeq(d.db, d.changeLog, "")
+	if err != nil {
+		return 0, fmt.Errorf("LastSeq failed: %w", err)
+	}
+	return seq, nil
+}
+
+// PruneChanges deletes the persisted change events up to and including seq.
+func (d *DB) PruneChanges(upTo int64) error {
+	err := pruneChanges(d.db, d.changeLog, upTo)
+	if err != nil {
+		return fmt.Errorf("PruneChanges failed: %w", err)
+	}
+	return nil
+}
+
+// Close closes the database. It is safe to call more than once.
+func (d *DB) Close() error {
+	d.closeOnce.Do(func() {
+		d.closeErr = d.db.Close()
+	})
+	return d.closeErr
+}
@@ -1,21 +1,34 @@
 package sqlitestore
 
 import (
+	"database/sql"
 	"fmt"
 	"reflect"
 	"strings"
 	"time"
 	"unicode"
+
+	"github.com/yinloo-ola/srbac/store"
 )
 
 type column struct {
-	Name       string
-	Index      int
-	IsPK       bool
-	IsIdxAsc   bool
-	IsIdxDesc  bool
-	IsIdxUniq  bool
-	SqLiteType sqliteType
+	Name         string
+	Index        int
+	IsPK         bool
+	IsIdxAsc     bool
+	IsIdxDesc    bool
+	IsIdxUniq    bool
+	IsVersion    bool
+	IsSoftDelete bool
+	IsAutoCreate bool
+	IsAutoUpdate bool
+	IsJSON       bool
+	IsNullable   bool
+	SqLiteType   sqliteType
+	// Composite and CompositeUniq name the multi-column indexes, declared with
+	// idx=name and uniq=name, that the column belongs to.
+	Composite     []string
+	CompositeUniq []string
 }
 type sqliteType string
 
@@ -23,28 +36,144 @@ const (
 	sqliteTypeText sqliteType = "TEXT"
 	sqliteTypeInt  sqliteType = "INTEGER"
 	sqliteTypeReal sqliteType = "REAL"
+	// sqliteTypeDatetime makes the driver parse the stored text back into a time.Time.
+	sqliteTypeDatetime sqliteType = "DATETIME"
+	sqliteTypeBlob     sqliteType = "BLOB"
 )
 
+var timeType = reflect.TypeOf(time.Time{})
+
+// nullTypes maps the sql.Null* types to the column type of the value they wrap.
+var nullTypes = map[reflect.Type]sqliteType{
+	reflect.TypeOf(sql.NullString{}):  sqliteTypeText,
+	reflect.TypeOf(sql.NullInt64{}):   sqliteTypeInt,
+	reflect.TypeOf(sql.NullInt32{}):   sqliteTypeInt,
+	reflect.TypeOf(sql.NullInt16{}):   sqliteTypeInt,
+	reflect.TypeOf(sql.NullByte{}):    sqliteTypeInt,
+	reflect.TypeOf(sql.NullBool{}):    sqliteTypeInt,
+	reflect.TypeOf(sql.NullFloat64{}): sqliteTypeReal,
+	reflect.TypeOf(sql.NullTime{}):    sqliteTypeDatetime,
+}
+
 func generateCreateTableSQL(tableName string, columns []column) string {
 	return fmt.Sprintf("CREATE TABLE if not exists %s (%s)", tableName, generateCreateColumnSQL(columns))
 }
 
-func generateCreateIdxSQL(tableName string, columns []column) string {
-	queries := make([]string, 0, len(columns))
+// Index and Indexer are declared in package store so that models can declare
+// indexes without importing this package.
+type (
+	Index   = store.Index
+	Indexer = store.Indexer
+)
+
+// getIndexes collects the single column indexes declared with idx_asc/idx_desc,
+// the composite indexes declared with idx=name or uniq=name, and extra. Index
+// names are qualified with the table name so they do not collide across tables.
+func getIndexes(tableName string, columns []column, extra []Index) ([]Index, error) {
+	var indexes []Index
+	composites := map[string]int{}
 	for _, col := range columns {
-		uniq := ""
-		if col.IsIdxUniq {
-			uniq = "UNIQUE "
-		}
 		if col.IsIdxAsc {
-			s := fmt.Sprintf("CREATE %sINDEX IF NOT EXISTS idx_%s ON %s (%s asc);", uniq, col.Name, tableName, col.Name)
-			queries = append(queries, s)
+			indexes = append(indexes, Index{Name: col.Name, Columns: []string{col.Name + " asc"}, Unique: col.IsIdxUniq})
 		} else if col.IsIdxDesc {
-			s := fmt.Sprintf("CREATE %sINDEX IF NOT EXISTS idx_%s ON %s (%s desc);", uniq, col.Name, tableName, col.Name)
-			queries = append(queries, s)
+			indexes = append(indexes, Index{Name: col.Name, Columns: []string{col.Name + " desc"}, Unique: col.IsIdxUniq})
+		}
+		for _, name := range col.Composite {
+			i, ok := composites[name]
+			if !ok {
+				i = len(indexes)
+				composites[name] = i
+				indexes = append(indexes, Index{Name: name})
+			}
+			indexes[i].Columns = append(indexes[i].Columns, col.Name)
+		}
+		for _, name := range col.CompositeUniq {
+			i, ok := composites[name]
+			if !ok {
+				i = len(indexes)
+				composites[name] = i
+				indexes = append(indexes, Index{Name: name})
+			}
+			indexes[i].Columns = append(indexes[i].Columns, col.Name)
+			indexes[i].Unique = true
+		}
+	}
+	indexes = append(indexes, extra...)
+
+	names := map[string]bool{}
+	for i, idx := range indexes {
+		if !validIdentifier(idx.Name) || idx.Name == "" {
+			return nil, fmt.Errorf("%s: invalid index name %q", tableName, idx.Name)
+		}
+		if names[idx.Name] {
+			return nil, fmt.Errorf("%s: duplicate index name %q", tableName, idx.Name)
+		}
+		names[idx.Name] = true
+		if len(idx.Columns) == 0 {
+			return nil, fmt.Errorf("%s: index %q has no columns", tableName, idx.Name)
+		}
+		for _, c := range idx.Columns {
+			name, order, _ := strings.Cut(strings.TrimSpace(c), " ")
+			if !hasColumn(columns, name) || (order != "" && !strings.EqualFold(order, "asc") && !strings.EqualFold(order, "desc")) {
+				return nil, fmt.Errorf("%s: index %q has invalid column %q", tableName, idx.Name, c)
+			}
+		}
+		indexes[i].Name = "idx_" + tableName + "_" + idx.Name
+	}
+	return indexes, nil
+}
+
+func generateCreateIdxSQL(tableName string, idx Index) string {
+	return strings.Replace(indexSQL(tableName, idx), "INDEX ", "INDEX IF NOT EXISTS ", 1)
+}
+
+// indexSQL returns the statement creating idx as SQLite records it in
+// sqlite_master, which lets changed index definitions be detected.
+func indexSQL(tableName string, idx Index) string {
+	uniq := ""
+	if idx.Unique {
+		uniq = "UNIQUE "
+	}
+	where := ""
+	if idx.Where != "" {
+		where = " WHERE " + idx.Where
+	}
+	return fmt.Sprintf("CREATE %sINDEX %s ON %s (%s)%s", uniq, idx.Name, tableName, strings.Join(idx.Columns, ", "), where)
+}
+
+// indexColumn returns the column name of an Index column, without its order.
+func indexColumn(c string) string {
+	name, _, _ := strings.Cut(strings.TrimSpace(c), " ")
+	return name
+}
+
+// legacyIdxNames returns the names single column indexes had before index names
+// were qualified with the table name.
+func legacyIdxNames(indexes []Index) []string {
+	current := map[string]bool{}
+	for _, idx := range indexes {
+		current[idx.Name] = true
+	}
+	var names []string
+	for _, idx := range indexes {
+		if len(idx.Columns) != 1 {
+			continue
+		}
+		name := "idx_" + indexColumn(idx.Columns[0])
+		if !current[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func hasColumn(columns []column, name string) bool {
+	for _, col := range columns {
+		if col.Name == name {
+			return true
 		}
 	}
-	return strings.Join(queries, " ")
+	return false
 }
 
 func generateCreateColumnSQL(columns []column) string {
@@ -66,47 +195,65 @@ func getColumns(typ reflect.Type) []column {
 		field := typ.Field(i)
 		tag := field.Tag.Get("db")
 
-		tagName, _, _ := strings.Cut(tag, ",")
+		tagName, tagOpts, _ := strings.Cut(tag, ",")
 
 		name := field.Name
 		if len(tagName) > 0 {
 			name = tagName
 		}
 
-		isPK := false
-		if strings.Contains(tag, ",pk") {
-			isPK = true
+		col := column{
+			Name:       name,
+			Index:      i,
+			IsJSON:     isJSONType(field.Type),
+			IsNullable: isNullableType(field.Type),
+			SqLiteType: getSQLiteType(field.Type),
 		}
-
-		isIdxAsc := false
-		isIdxDesc := false
-		if strings.Contains(tag, ",idx_asc") {
-			isIdxAsc = true
-		} else if strings.Contains(tag, ",idx_desc") {
-			isIdxDesc = true
+		for _, opt := range strings.Split(tagOpts, ",") {
+			opt, val, _ := strings.Cut(opt, "=")
+			switch opt {
+			case "pk":
+				col.IsPK = true
+			case "idx_asc":
+				col.IsIdxAsc = true
+			case "idx_desc":
+				col.IsIdxDesc = true
+			case "uniq":
+				if val != "" {
+					col.CompositeUniq = append(col.CompositeUniq, val)
+				} else {
+					col.IsIdxUniq = true
+				}
+			case "idx":
+				col.Composite = append(col.Composite, val)
+			case "version":
+				col.IsVersion = true
+			case "soft_delete":
+				col.IsSoftDelete = true
+			case "autocreate":
+				col.IsAutoCreate = true
+			case "autoupdate":
+				col.IsAutoUpdate = true
+			case "json":
+				col.IsJSON = true
+			}
 		}
-
-		isUniqIdx := false
-		if strings.Contains(tag, ",uniq") {
-			isUniqIdx = true
+		if col.IsIdxAsc && col.IsIdxDesc {
+			col.IsIdxDesc = false
 		}
 
-		sqlType := getSQLiteType(field.Type)
-
-		columns = append(columns, column{
-			Name:       name,
-			Index:      i,
-			IsPK:       isPK,
-			IsIdxAsc:   isIdxAsc,
-			IsIdxDesc:  isIdxDesc,
-			IsIdxUniq:  isUniqIdx,
-			SqLiteType: sqlType,
-		})
+		columns = append(columns, col)
 	}
 	return columns
 }
 
 func getSQLiteType(field reflect.Type) sqliteType {
+	if field == timeType {
+		return sqliteTypeDatetime
+	}
+	if typ, ok := nullTypes[field]; ok {
+		return typ
+	}
 	switch field.Kind() {
 	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint8, reflect.Int16, reflect.Int32, reflect.Int8:
 		return sqliteTypeInt
@@ -119,19 +266,77 @@ func getSQLiteType(field reflect.Type) sqliteType {
 	case reflect.Struct:
 		return sqliteTypeText
 	case reflect.Pointer:
-		if isPrimitive(field.Elem().Kind()) {
-			panic("pointer to primitive is not supported")
+		if isScalarType(field.Elem()) {
+			return getSQLiteType(field.Elem())
 		}
 		return sqliteTypeText
 	case reflect.Array:
 		return sqliteTypeText
 	case reflect.Slice:
+		if field.Elem().Kind() == reflect.Uint8 {
+			return sqliteTypeBlob
+		}
+		return sqliteTypeText
+	case reflect.Map:
 		return sqliteTypeText
 	default:
 		panic("unsupported type")
 	}
 }
 
+// isScalarType reports whether a field of type field is stored in a single
+// column as is, without being encoded as JSON.
+func isScalarType(field reflect.Type) bool {
+	if field == timeType {
+		return true
+	}
+	if _, ok := nullTypes[field]; ok {
+		return true
+	}
+	if field.Kind() == reflect.Slice {
+		return field.Elem().Kind() == reflect.Uint8
+	}
+	return isPrimitive(field.Kind()) || field.Kind() == reflect.String
+}
+
+// isJSONType reports whether a field of type field is stored as JSON by models.
+func isJSONType(field reflect.Type) bool {
+	switch field.Kind() {
+	case reflect.Struct, reflect.Slice, reflect.Array, reflect.Map:
+		return !isScalarType(field)
+	case reflect.Pointer:
+		return !isScalarType(field.Elem())
+	default:
+		return false
+	}
+}
+
+// isNullableType reports whether a field of type field can hold SQL NULL.
+func isNullableType(field reflect.Type) bool {
+	if _, ok := nullTypes[field]; ok {
+		return true
+	}
+	return field.Kind() == reflect.Pointer && isScalarType(field.Elem())
+}
+
+// normalizeValue converts times to UTC so that stored times sort correctly.
+func normalizeValue(val any) any {
+	switch v := val.(type) {
+	case time.Time:
+		return v.UTC()
+	case *time.Time:
+		if v != nil {
+			return v.UTC()
+		}
+	case sql.NullTime:
+		if v.Valid {
+			v.Time = v.Time.UTC()
+		}
+		return v
+	}
+	return val
+}
+
 func isPrimitive(kind reflect.Kind) bool {
 	switch kind {
 	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
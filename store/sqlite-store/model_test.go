@@ -2,9 +2,11 @@ package sqlitestore
 
 import (
 	"bytes"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"io"
+	"time"
 
 	"github.com/yinloo-ola/srbac/store"
 )
@@ -129,3 +131,148 @@ type Address struct {
 	City   string
 	Zip    []string
 }
+
+// Misordered returns Name and Description out of struct order in FieldsVals
+// while ScanRow mirrors the same mistake, so a naive round trip succeeds.
+type Misordered struct {
+	Id          int64  `db:"id,pk"`
+	Name        string `db:"name"`
+	Description string `db:"description"`
+}
+
+func (o *Misordered) FieldsVals() []any {
+	return []any{o.Id, o.Description, o.Name}
+}
+
+func (o *Misordered) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.Id, &o.Description, &o.Name)
+}
+
+// MisScanned returns fields in struct order but scans them out of order.
+type MisScanned struct {
+	Id          int64  `db:"id,pk"`
+	Name        string `db:"name"`
+	Description string `db:"description"`
+}
+
+func (o *MisScanned) FieldsVals() []any {
+	return []any{o.Id, o.Name, o.Description}
+}
+
+func (o *MisScanned) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.Id, &o.Description, &o.Name)
+}
+
+type Versioned struct {
+	Id      int64  `db:"id,pk"`
+	Name    string `db:"name"`
+	Version int64  `db:"version,version"`
+}
+
+func (o *Versioned) FieldsVals() []any {
+	return []any{o.Id, o.Name, o.Version}
+}
+
+func (o *Versioned) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.Id, &o.Name, &o.Version)
+}
+
+type SoftDeleted struct {
+	Id        int64  `db:"id,pk"`
+	Name      string `db:"name,idx_asc"`
+	DeletedAt int64  `db:"deleted_at,soft_delete"`
+}
+
+func (o *SoftDeleted) FieldsVals() []any {
+	return []any{o.Id, o.Name, o.DeletedAt}
+}
+
+func (o *SoftDeleted) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.Id, &o.Name, &o.DeletedAt)
+}
+
+type Timestamped struct {
+	Id        int64     `db:"id,pk"`
+	Name      string    `db:"name,idx_asc,uniq"`
+	LastSeen  time.Time `db:"last_seen"`
+	CreatedAt time.Time `db:"created_at,autocreate"`
+	UpdatedAt time.Time `db:"updated_at,autoupdate"`
+}
+
+func (o *Timestamped) FieldsVals() []any {
+	return []any{o.Id, o.Name, o.LastSeen, o.CreatedAt, o.UpdatedAt}
+}
+
+func (o *Timestamped) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.Id, &o.Name, &o.LastSeen, &o.CreatedAt, &o.UpdatedAt)
+}
+
+type Named struct {
+	Id   int64  `db:"id,pk"`
+	Name string `db:"name"`
+}
+
+func (o *Named) TableName() string {
+	return "custom_named"
+}
+
+func (o *Named) FieldsVals() []any {
+	return []any{o.Id, o.Name}
+}
+
+func (o *Named) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.Id, &o.Name)
+}
+
+type Membership struct {
+	Id       int64  `db:"id,pk"`
+	TenantID int64  `db:"tenant_id,uniq=tenant_user"`
+	UserID   string `db:"user_id,idx_asc,uniq=tenant_user"`
+	Role     string `db:"role"`
+	Active   bool   `db:"active"`
+}
+
+func (o *Membership) Indexes() []Index {
+	return []Index{{Name: "active_role", Columns: []string{"role"}, Where: "active = 1"}}
+}
+
+func (o *Membership) FieldsVals() []any {
+	return []any{o.Id, o.TenantID, o.UserID, o.Role, o.Active}
+}
+
+func (o *Membership) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.Id, &o.TenantID, &o.UserID, &o.Role, &o.Active)
+}
+
+type Typed struct {
+	Id       int64           `db:"id,pk"`
+	Active   bool            `db:"active"`
+	Avatar   []byte          `db:"avatar"`
+	Nick     *string         `db:"nick"`
+	Age      *int64          `db:"age"`
+	SeenAt   *time.Time      `db:"seen_at"`
+	Note     sql.NullString  `db:"note"`
+	Score    sql.NullFloat64 `db:"score"`
+	ExpireAt sql.NullTime    `db:"expire_at"`
+}
+
+func (o *Typed) FieldsVals() []any {
+	return []any{o.Id, o.Active, o.Avatar, o.Nick, o.Age, o.SeenAt, o.Note, o.Score, o.ExpireAt}
+}
+
+func (o *Typed) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.Id, &o.Active, &o.Avatar, &o.Nick, &o.Age, &o.SeenAt, &o.Note, &o.Score, &o.ExpireAt)
+}
+
+// Plain has no row methods and is only stored through NewReflectStore.
+type Plain struct {
+	Id        int64             `db:"id,pk"`
+	Name      string            `db:"name,idx_asc,uniq"`
+	Tags      []string          `db:"tags"`
+	Attrs     map[string]string `db:"attrs"`
+	Address   *Address          `db:"address"`
+	Nick      *string           `db:"nick"`
+	Version   int64             `db:"version,version"`
+	CreatedAt time.Time         `db:"created_at,autocreate"`
+	DeletedAt int64             `db:"deleted_at,soft_delete"`
+}
@@ -0,0 +1,179 @@
+package sqlitestore
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Option configures how Open and NewStore connect to the SQLite database.
+type Option func(*options)
+
+type options struct {
+	journalMode     string
+	synchronous     string
+	busyTimeout     time.Duration
+	foreignKeys     bool
+	cacheSizeKiB    int
+	maxOpenConns    int
+	maxIdleConns    int
+	connMaxLifetime time.Duration
+	readOnly        bool
+	inMemory        bool
+	tableName       string
+	tablePrefix     string
+}
+
+func defaultOptions() options {
+	return options{
+		journalMode:  "wal",
+		synchronous:  "1",
+		maxIdleConns: 2,
+	}
+}
+
+// WithJournalMode sets the journal mode, "wal" by default.
+func WithJournalMode(mode string) Option {
+	return func(o *options) {
+		o.journalMode = mode
+	}
+}
+
+// WithSynchronous sets the synchronous level, "1" (NORMAL) by default.
+func WithSynchronous(level string) Option {
+	return func(o *options) {
+		o.synchronous = level
+	}
+}
+
+// WithBusyTimeout makes a connection wait up to d for a lock held by another
+// connection or process instead of failing with SQLITE_BUSY.
+func WithBusyTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.busyTimeout = d
+	}
+}
+
+// WithForeignKeys enables foreign key enforcement.
+func WithForeignKeys() Option {
+	return func(o *options) {
+		o.foreignKeys = true
+	}
+}
+
+// WithCacheSize sets the page cache size of each connection in KiB.
+func WithCacheSize(kib int) Option {
+	return func(o *options) {
+		o.cacheSizeKiB = kib
+	}
+}
+
+// WithMaxOpenConns limits the number of open connections, see sql.DB.SetMaxOpenConns.
+func WithMaxOpenConns(n int) Option {
+	return func(o *options) {
+		o.maxOpenConns = n
+	}
+}
+
+// WithMaxIdleConns sets the number of idle connections kept, see sql.DB.SetMaxIdleConns.
+func WithMaxIdleConns(n int) Option {
+	return func(o *options) {
+		o.maxIdleConns = n
+	}
+}
+
+// WithConnMaxLifetime sets how long a connection may be reused, see sql.DB.SetConnMaxLifetime.
+func WithConnMaxLifetime(d time.Duration) Option {
+	return func(o *options) {
+		o.connMaxLifetime = d
+	}
+}
+
+// WithReadOnly opens an existing database read-only. Tables and indexes are not
+// created and all writes fail.
+func WithReadOnly() Option {
+	return func(o *options) {
+		o.readOnly = true
+	}
+}
+
+// WithInMemory keeps the database in memory under the name passed as path.
+// All connections opened with the same name share the database through a
+// shared cache, which lives until the last connection is closed. As the shared
+// cache locks a table while it is read, Iterate loads all matching rows before
+// calling its callback on such a database.
+func WithInMemory() Option {
+	return func(o *options) {
+		o.inMemory = true
+	}
+}
+
+// WithTableName overrides the table name of a store, which defaults to the
+// TableName of the model if it implements TableNamer, or else the snake case of
+// its type name. It only applies to NewStore and NewStoreFromDB.
+func WithTableName(name string) Option {
+	return func(o *options) {
+		o.tableName = name
+	}
+}
+
+// WithTablePrefix prepends prefix to table names. Passed to Open it applies to
+// all stores of the DB and to its change log table.
+func WithTablePrefix(prefix string) Option {
+	return func(o *options) {
+		o.tablePrefix = prefix
+	}
+}
+
+// validIdentifier reports whether s can be used unquoted as part of a table name.
+func validIdentifier(s string) bool {
+	for i, c := range s {
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// dataSourceName returns the DSN for path. Pragmas are passed in the DSN so that
+// every connection of the pool gets them, and times are stored in a sortable format.
+func (o options) dataSourceName(path string) string {
+	q := url.Values{}
+	q.Add("_time_format", "sqlite")
+	if o.journalMode != "" && !o.inMemory && !o.readOnly {
+		q.Add("_pragma", fmt.Sprintf("journal_mode(%s)", o.journalMode))
+	}
+	if o.synchronous != "" {
+		q.Add("_pragma", fmt.Sprintf("synchronous(%s)", o.synchronous))
+	}
+	if o.busyTimeout > 0 {
+		q.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", o.busyTimeout.Milliseconds()))
+	}
+	if o.foreignKeys {
+		q.Add("_pragma", "foreign_keys(1)")
+	}
+	if o.cacheSizeKiB > 0 {
+		q.Add("_pragma", fmt.Sprintf("cache_size(-%d)", o.cacheSizeKiB))
+	}
+
+	uri := false
+	if o.inMemory {
+		q.Set("mode", "memory")
+		q.Set("cache", "shared")
+		uri = true
+	} else if o.readOnly {
+		q.Set("mode", "ro")
+		uri = true
+	}
+
+	if uri && !strings.HasPrefix(path, "file:") {
+		path = "file:" + path
+	}
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return path + sep + q.Encode()
+}
@@ -0,0 +1,139 @@
+package sqlitestore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/yinloo-ola/srbac/store"
+)
+
+// rowCodec converts between a T and the values of its columns in struct order.
+type rowCodec[T any] struct {
+	fieldsVals func(obj *T) []any
+	scanRow    func(obj *T, row store.RowScanner) error
+	// validate, if set, checks the codec against the columns of the table.
+	validate func(db *sql.DB, tableName string, columns []column) error
+}
+
+// rowMethods returns the codec calling the hand-written methods of R.
+func rowMethods[T any, R store.Row[T]]() rowCodec[T] {
+	return rowCodec[T]{
+		fieldsVals: func(obj *T) []any {
+			return R(obj).FieldsVals()
+		},
+		scanRow: func(obj *T, row store.RowScanner) error {
+			return R(obj).ScanRow(row)
+		},
+		validate: validateRow[T, R],
+	}
+}
+
+// reflectCodec returns the codec reading and writing the fields of T through
+// reflection. The columns of T are reflected once, here.
+func reflectCodec[T any]() (codec rowCodec[T], err error) {
+	var obj T
+	typ := reflect.TypeOf(obj)
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return codec, fmt.Errorf("%v is not a struct", typ)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v: %v", typ, r)
+		}
+	}()
+	columns := getColumns(typ)
+
+	codec.fieldsVals = func(obj *T) []any {
+		elem := reflect.ValueOf(obj).Elem()
+		vals := make([]any, len(columns))
+		for i, col := range columns {
+			field := elem.Field(col.Index)
+			if !col.IsJSON {
+				vals[i] = field.Interface()
+				continue
+			}
+			b, err := json.Marshal(field.Interface())
+			if err != nil {
+				panic(fmt.Errorf("fail to encode %s: %w", col.Name, err))
+			}
+			vals[i] = b
+		}
+		return vals
+	}
+	codec.scanRow = func(obj *T, row store.RowScanner) error {
+		return scanFields(reflect.ValueOf(obj).Elem(), columns, row)
+	}
+	return codec, nil
+}
+
+// scanFields scans row into the fields of elem holding cols, decoding the JSON
+// columns.
+func scanFields(elem reflect.Value, cols []column, row store.RowScanner) error {
+	dests := make([]any, len(cols))
+	jsonVals := make([][]byte, len(cols))
+	for i, col := range cols {
+		if col.IsJSON {
+			dests[i] = &jsonVals[i]
+			continue
+		}
+		dests[i] = elem.Field(col.Index).Addr().Interface()
+	}
+	err := row.Scan(dests...)
+	if err != nil {
+		return err
+	}
+	for i, col := range cols {
+		if !col.IsJSON || len(jsonVals[i]) == 0 {
+			continue
+		}
+		err = json.Unmarshal(jsonVals[i], elem.Field(col.Index).Addr().Interface())
+		if err != nil {
+			return fmt.Errorf("fail to decode %s: %w", col.Name, err)
+		}
+	}
+	return nil
+}
+
+// NewReflectStore is NewStore for a struct T with db tags that does not
+// implement store.Row. The fields of T are read and written through
+// reflection, with non-scalar fields encoded as JSON. See the Reflect and Row
+// benchmarks for its cost compared with hand-written FieldsVals and ScanRow.
+// The returned store has the methods of store.Store but, as *T is not a
+// store.Row, it is used through its own type rather than that interface.
+func NewReflectStore[T any](path string, opts ...Option) (*SQliteStore[T, *T], error) {
+	codec, err := reflectCodec[T]()
+	if err != nil {
+		return nil, err
+	}
+	db, err := Open(path, opts...)
+	if err != nil {
+		return nil, err
+	}
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	s, err := newStore[T, *T](db, o, codec)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	s.ownsDB = true
+	return s, nil
+}
+
+// NewReflectStoreFromDB is NewStoreFromDB for a struct T read and written
+// through reflection. See NewReflectStore.
+func NewReflectStoreFromDB[T any](db *DB, opts ...Option) (*SQliteStore[T, *T], error) {
+	codec, err := reflectCodec[T]()
+	if err != nil {
+		return nil, err
+	}
+	o := options{tablePrefix: db.prefix}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return newStore[T, *T](db, o, codec)
+}
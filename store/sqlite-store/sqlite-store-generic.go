@@ -7,61 +7,151 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"time"
 
-	_ "modernc.org/sqlite"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 
 	"github.com/yinloo-ola/srbac/store"
 )
 
-type SQliteStore[T any, R store.Row[T]] struct {
+// TableNamer can be implemented by a model to choose its table name instead of
+// the snake case of its type name.
+type TableNamer interface {
+	TableName() string
+}
+
+// SQliteStore stores T in a table of a SQLite database. R is *T; NewStore
+// requires it to implement store.Row[T] while NewReflectStore reads and writes
+// the fields of T through reflection.
+type SQliteStore[T any, R any] struct {
 	db         *sql.DB
 	tablename  string
 	pk         string
+	version    string
+	softDelete string
+	autoUpdate string
 	getOneStmt *sql.Stmt
 	insertStmt *sql.Stmt
 	updateStmt *sql.Stmt
 	getAllStmt *sql.Stmt
 	columns    []column
-	sync.RWMutex
+	indexes    []Index
+	feed       *changeFeed
+	owner      *DB
+	ownsDB     bool
+	codec      rowCodec[T]
+	*sync.RWMutex
 }
 
-func NewStore[T any, R store.Row[T]](path string) (*SQliteStore[T, R], error) {
-	db, err := sql.Open("sqlite", path)
+// NewStore opens the SQLite database at path with opts for the store of T alone.
+// Use Open and NewStoreFromDB to share one database between several stores.
+func NewStore[T any, R store.Row[T]](path string, opts ...Option) (*SQliteStore[T, R], error) {
+	db, err := Open(path, opts...)
 	if err != nil {
 		return nil, err
 	}
-	_, err = db.Exec("PRAGMA journal_mode = wal;")
-	if err != nil {
-		return nil, err
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
 	}
-	_, err = db.Exec("PRAGMA synchronous=1;")
+	s, err := newStore[T, R](db, o, rowMethods[T, R]())
 	if err != nil {
+		_ = db.Close()
 		return nil, err
 	}
+	s.ownsDB = true
+	return s, nil
+}
 
+func newStore[T any, R any](owner *DB, opts options, codec rowCodec[T]) (*SQliteStore[T, R], error) {
+	db := owner.db
 	var obj T
 	typ := reflect.TypeOf(obj)
-	tableName := toSnakeCase(typ.Name())
+	tableName := opts.tableName
+	if tableName == "" {
+		if namer, ok := any(&obj).(TableNamer); ok {
+			tableName = namer.TableName()
+		} else {
+			tableName = toSnakeCase(typ.Name())
+		}
+	}
+	tableName = opts.tablePrefix + tableName
+	if tableName == "" || !validIdentifier(tableName) {
+		return nil, fmt.Errorf("invalid table name %q", tableName)
+	}
 	columns := getColumns(typ)
 
 	pk := ""
+	version := ""
+	softDelete := ""
+	autoUpdate := ""
 	for _, col := range columns {
 		if col.IsPK {
 			pk = col.Name
-			break
+		}
+		if col.IsVersion {
+			if col.SqLiteType != sqliteTypeInt {
+				return nil, fmt.Errorf("%s: version column %q must be an integer", tableName, col.Name)
+			}
+			version = col.Name
+		}
+		if col.IsSoftDelete {
+			if col.SqLiteType != sqliteTypeInt {
+				return nil, fmt.Errorf("%s: soft delete column %q must be an integer", tableName, col.Name)
+			}
+			softDelete = col.Name
+		}
+		if (col.IsAutoCreate || col.IsAutoUpdate) && col.SqLiteType != sqliteTypeDatetime {
+			return nil, fmt.Errorf("%s: autocreate/autoupdate column %q must be a time.Time", tableName, col.Name)
+		}
+		if col.IsAutoUpdate {
+			autoUpdate = col.Name
 		}
 	}
 
-	stmt := generateCreateTableSQL(tableName, columns)
-	_, err = db.Exec(stmt)
+	var extraIndexes []Index
+	if indexer, ok := any(&obj).(Indexer); ok {
+		extraIndexes = indexer.Indexes()
+	}
+	indexes, err := getIndexes(tableName, columns, extraIndexes)
 	if err != nil {
 		return nil, err
 	}
 
-	stmt = generateCreateIdxSQL(tableName, columns)
-	_, err = db.Exec(stmt)
-	if err != nil {
-		return nil, err
+	if codec.validate != nil {
+		err = codec.validate(db, tableName, columns)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !owner.readOnly {
+		stmt := generateCreateTableSQL(tableName, columns)
+		_, err = db.Exec(stmt)
+		if err != nil {
+			return nil, err
+		}
+
+		err = addMissingColumns(db, tableName, columns)
+		if err != nil {
+			return nil, err
+		}
+
+		err = dropLegacyIdx(db, tableName, indexes)
+		if err != nil {
+			return nil, err
+		}
+
+		err = dropChangedIdx(db, tableName, indexes)
+		if err != nil {
+			return nil, err
+		}
+
+		err = createIdx(db, tableName, indexes)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	placeholdersNoPK := make([]string, 0, len(columns))
@@ -70,14 +160,31 @@ func NewStore[T any, R store.Row[T]](path string) (*SQliteStore[T, R], error) {
 	updates := make([]string, 0, len(columns))
 	for _, col := range columns {
 		columnNames = append(columnNames, col.Name)
-		if !col.IsPK {
-			columnNamesNoPK = append(columnNamesNoPK, col.Name)
-			placeholdersNoPK = append(placeholdersNoPK, "?")
-			updates = append(updates, col.Name+"=?")
+		if col.IsPK {
+			continue
+		}
+		columnNamesNoPK = append(columnNamesNoPK, col.Name)
+		if col.IsVersion {
+			placeholdersNoPK = append(placeholdersNoPK, "1")
+			updates = append(updates, col.Name+"="+col.Name+"+1")
+			continue
+		}
+		if col.IsSoftDelete {
+			placeholdersNoPK = append(placeholdersNoPK, "0")
+			continue
+		}
+		placeholdersNoPK = append(placeholdersNoPK, "?")
+		if col.IsAutoCreate {
+			continue
 		}
+		updates = append(updates, col.Name+"=?")
+	}
+	live := ""
+	if softDelete != "" {
+		live = fmt.Sprintf(" and %s=0", softDelete)
 	}
 
-	getOneQuery := fmt.Sprintf("SELECT %s from %s where %s=?", strings.Join(columnNames, ","), tableName, pk)
+	getOneQuery := fmt.Sprintf("SELECT %s from %s where %s=?%s", strings.Join(columnNames, ","), tableName, pk, live)
 	getOneStmt, err := db.Prepare(getOneQuery)
 	if err != nil {
 		return nil, err
@@ -93,82 +200,248 @@ func NewStore[T any, R store.Row[T]](path string) (*SQliteStore[T, R], error) {
 		return nil, err
 	}
 
-	updateQuery := fmt.Sprintf("UPDATE %s SET %s where %s=?",
+	updateQuery := fmt.Sprintf("UPDATE %s SET %s where %s=?%s",
 		tableName,
 		strings.Join(updates, ", "),
 		pk,
+		live,
 	)
+	if version != "" {
+		updateQuery += fmt.Sprintf(" and %s=?", version)
+	}
 	updateStmt, err := db.Prepare(updateQuery)
 	if err != nil {
 		return nil, err
 	}
 
 	getAllQuery := fmt.Sprintf("SELECT %s from %s", strings.Join(columnNames, ","), tableName)
+	if softDelete != "" {
+		getAllQuery += fmt.Sprintf(" where %s=0", softDelete)
+	}
 	getAllstmt, err := db.Prepare(getAllQuery)
 	if err != nil {
 		return nil, err
 	}
 
 	return &SQliteStore[T, R]{
-		db: db, tablename: tableName, columns: columns, pk: pk,
+		db: db, tablename: tableName, columns: columns, indexes: indexes, pk: pk, version: version, softDelete: softDelete,
+		autoUpdate: autoUpdate,
 		getOneStmt: getOneStmt, insertStmt: insertStmt, updateStmt: updateStmt,
-		getAllStmt: getAllstmt,
+		getAllStmt: getAllstmt, feed: owner.feed, owner: owner, codec: codec, RWMutex: owner.lock,
 	}, nil
 }
 
 func (o *SQliteStore[T, R]) Insert(obj T) (int64, error) {
-	o.Lock()
-	defer o.Unlock()
-	values := make([]any, 0, len(o.columns))
-	k := R(&obj)
+	ids, err := o.write(store.ChangeInsert, func(tx *sql.Tx) ([]int64, error) {
+		res, err := tx.Stmt(o.insertStmt).Exec(o.writeValues(&obj, time.Now(), true)...)
+		if err != nil {
+			return nil, fmt.Errorf("%s insert failed: %w", o.tablename, err)
+		}
+
+		id, err := res.LastInsertId()
+		if err != nil {
+			return nil, fmt.Errorf("%s fail to get last insert id: %w", o.tablename, err)
+		}
+		return []int64{id}, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+func (o *SQliteStore[T, R]) InsertMulti(objs []T) ([]int64, error) {
+	if len(objs) == 0 {
+		return nil, nil
+	}
+	return o.write(store.ChangeInsert, func(tx *sql.Tx) ([]int64, error) {
+		stmt := tx.Stmt(o.insertStmt)
+		now := time.Now()
+		ids := make([]int64, 0, len(objs))
+		for i := range objs {
+			res, err := stmt.Exec(o.writeValues(&objs[i], now, true)...)
+			if err != nil {
+				return nil, fmt.Errorf("%s InsertMulti insert failed: %w", o.tablename, err)
+			}
+			id, err := res.LastInsertId()
+			if err != nil {
+				return nil, fmt.Errorf("%s InsertMulti fail to get last insert id: %w", o.tablename, err)
+			}
+			ids = append(ids, id)
+		}
+		return ids, nil
+	})
+}
+
+func (o *SQliteStore[T, R]) Upsert(objs []T, keyField string) ([]int64, error) {
+	conflict, ok := o.conflictTarget(keyField)
+	if !ok {
+		return nil, fmt.Errorf("%s Upsert: %q is not a unique indexed column", o.tablename, keyField)
+	}
+	if len(objs) == 0 {
+		return nil, nil
+	}
 
-	fieldPtrs := k.FieldsVals()
+	columnNamesNoPK := make([]string, 0, len(o.columns))
+	placeholdersNoPK := make([]string, 0, len(o.columns))
+	updates := make([]string, 0, len(o.columns))
 	for _, col := range o.columns {
 		if col.IsPK {
 			continue
 		}
-		val := fieldPtrs[col.Index]
-		values = append(values, val)
+		columnNamesNoPK = append(columnNamesNoPK, col.Name)
+		if col.IsVersion {
+			placeholdersNoPK = append(placeholdersNoPK, "1")
+			updates = append(updates, col.Name+"="+col.Name+"+1")
+			continue
+		}
+		if col.IsSoftDelete {
+			placeholdersNoPK = append(placeholdersNoPK, "0")
+			updates = append(updates, col.Name+"=0")
+			continue
+		}
+		placeholdersNoPK = append(placeholdersNoPK, "?")
+		if col.IsAutoCreate {
+			continue
+		}
+		updates = append(updates, col.Name+"=excluded."+col.Name)
 	}
+	upsertQuery := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT%s DO UPDATE SET %s RETURNING %s",
+		o.tablename,
+		strings.Join(columnNamesNoPK, ", "),
+		strings.Join(placeholdersNoPK, ", "),
+		conflict,
+		strings.Join(updates, ", "),
+		o.pk,
+	)
 
-	res, err := o.insertStmt.Exec(values...)
-	if err != nil {
-		return 0, fmt.Errorf("%s insert failed: %w", o.tablename, err)
-	}
+	return o.write(store.ChangeUpsert, func(tx *sql.Tx) ([]int64, error) {
+		stmt, err := tx.Prepare(upsertQuery)
+		if err != nil {
+			return nil, fmt.Errorf("%s Upsert prepare failed: %w", o.tablename, err)
+		}
+		defer stmt.Close()
 
-	id, err := res.LastInsertId()
-	if err != nil {
-		return 0, fmt.Errorf("%s fail to get last insert id: %w", o.tablename, err)
-	}
-	return id, nil
+		now := time.Now()
+		ids := make([]int64, 0, len(objs))
+		for i := range objs {
+			var id int64
+			err = stmt.QueryRow(o.writeValues(&objs[i], now, true)...).Scan(&id)
+			if err != nil {
+				return nil, fmt.Errorf("%s Upsert failed: %w", o.tablename, err)
+			}
+			ids = append(ids, id)
+		}
+		return ids, nil
+	})
 }
 
 func (o *SQliteStore[T, R]) Update(id int64, obj T) error {
-	o.Lock()
-	defer o.Unlock()
-	values := make([]any, 0, len(o.columns))
-	k := R(&obj)
-	fieldPtrs := k.FieldsVals()
-	for _, col := range o.columns {
+	values := append(o.writeValues(&obj, time.Now(), false), id)
+	if o.version != "" {
+		versionCol, _ := o.column(o.version)
+		values = append(values, o.codec.fieldsVals(&obj)[versionCol.Index])
+	}
+
+	_, err := o.write(store.ChangeUpdate, func(tx *sql.Tx) ([]int64, error) {
+		res, err := tx.Stmt(o.updateStmt).Exec(values...)
+		if err != nil {
+			return nil, fmt.Errorf("%s update failed: %w", o.tablename, err)
+		}
+		return []int64{id}, o.checkUpdated(tx, res, id, o.version != "")
+	})
+	return err
+}
+
+func (o *SQliteStore[T, R]) UpdateFields(id int64, obj T, fields ...string) error {
+	if len(fields) == 0 {
+		return fmt.Errorf("%s UpdateFields: no fields given", o.tablename)
+	}
+	cols := make([]column, 0, len(fields))
+	for _, field := range fields {
+		col, ok := o.column(field)
+		if !ok {
+			return fmt.Errorf("%s UpdateFields: unknown field %q", o.tablename, field)
+		}
 		if col.IsPK {
+			return fmt.Errorf("%s UpdateFields: primary key %q cannot be updated", o.tablename, field)
+		}
+		if col.IsSoftDelete {
+			return fmt.Errorf("%s UpdateFields: use DeleteMulti or Restore to change %q", o.tablename, field)
+		}
+		if col.IsAutoCreate {
+			return fmt.Errorf("%s UpdateFields: autocreate column %q cannot be updated", o.tablename, field)
+		}
+		cols = append(cols, col)
+	}
+
+	fieldPtrs := o.codec.fieldsVals(&obj)
+	updates := make([]string, 0, len(cols)+1)
+	values := make([]any, 0, len(cols)+2)
+	var expectedVersion any
+	for _, col := range cols {
+		if col.IsVersion {
+			expectedVersion = fieldPtrs[col.Index]
 			continue
 		}
-		val := fieldPtrs[col.Index]
-		values = append(values, val)
+		if col.IsAutoUpdate {
+			continue
+		}
+		updates = append(updates, col.Name+"=?")
+		values = append(values, normalizeValue(fieldPtrs[col.Index]))
+	}
+	if o.version != "" {
+		updates = append(updates, o.version+"="+o.version+"+1")
+	}
+	if o.autoUpdate != "" {
+		updates = append(updates, o.autoUpdate+"=?")
+		values = append(values, time.Now().UTC())
 	}
 	values = append(values, id)
 
-	res, err := o.updateStmt.Exec(values...)
-	if err != nil {
-		return fmt.Errorf("%s update failed: %w", o.tablename, err)
+	updateQuery := fmt.Sprintf("UPDATE %s SET %s where %s=?%s",
+		o.tablename,
+		strings.Join(updates, ", "),
+		o.pk,
+		o.liveCond(" and "),
+	)
+	if expectedVersion != nil {
+		updateQuery += fmt.Sprintf(" and %s=?", o.version)
+		values = append(values, expectedVersion)
 	}
 
-	if rowsAffected, err := res.RowsAffected(); err != nil {
+	_, err := o.write(store.ChangeUpdate, func(tx *sql.Tx) ([]int64, error) {
+		res, err := tx.Exec(updateQuery, values...)
+		if err != nil {
+			return nil, fmt.Errorf("%s UpdateFields failed: %w", o.tablename, err)
+		}
+		return []int64{id}, o.checkUpdated(tx, res, id, expectedVersion != nil)
+	})
+	return err
+}
+
+// checkUpdated maps an update that affected no rows to store.ErrNotFound, or to
+// store.ErrConflict when the update was guarded by a version and the row exists.
+func (o *SQliteStore[T, R]) checkUpdated(tx *sql.Tx, res sql.Result, id int64, versioned bool) error {
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
 		return fmt.Errorf("%s failed to get rows affected: %w", o.tablename, err)
-	} else if rowsAffected == 0 {
+	}
+	if rowsAffected > 0 {
+		return nil
+	}
+	if !versioned {
 		return store.ErrNotFound
 	}
-	return nil
+	var exists int
+	err = tx.QueryRow(fmt.Sprintf("SELECT 1 from %s where %s=?%s", o.tablename, o.pk, o.liveCond(" and ")), id).Scan(&exists)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.ErrNotFound
+		}
+		return fmt.Errorf("%s fail to check existence: %w", o.tablename, err)
+	}
+	return store.ErrConflict
 }
 
 func (o *SQliteStore[T, R]) GetMulti(ids []int64) ([]T, error) {
@@ -180,7 +453,8 @@ func (o *SQliteStore[T, R]) GetMulti(ids []int64) ([]T, error) {
 	}
 
 	placeholders, args := InArgs(ids)
-	query := fmt.Sprintf("SELECT %s from %s where %s in (%s)", strings.Join(columnNames, ","), o.tablename, o.pk, placeholders)
+	query := fmt.Sprintf("SELECT %s from %s where %s in (%s)%s",
+		strings.Join(columnNames, ","), o.tablename, o.pk, placeholders, o.liveCond(" and "))
 
 	rows, err := o.db.Query(query, args...)
 	if err != nil {
@@ -194,8 +468,7 @@ func (o *SQliteStore[T, R]) GetMulti(ids []int64) ([]T, error) {
 	objs := make([]T, 0, len(ids))
 	for rows.Next() {
 		var obj T
-		k := R(&obj)
-		err = k.ScanRow(rows)
+		err = o.codec.scanRow(&obj, rows)
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
 				return nil, store.ErrNotFound
@@ -211,14 +484,13 @@ func (o *SQliteStore[T, R]) GetOne(id int64) (T, error) {
 	o.RLock()
 	defer o.RUnlock()
 	var obj T
-	k := R(&obj)
 
 	row := o.getOneStmt.QueryRow(id)
 	if row == nil {
 		return obj, store.ErrNotFound
 	}
 
-	err := k.ScanRow(row)
+	err := o.codec.scanRow(&obj, row)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return obj, store.ErrNotFound
@@ -228,43 +500,175 @@ func (o *SQliteStore[T, R]) GetOne(id int64) (T, error) {
 	return obj, nil
 }
 
+// DeleteMulti deletes the rows with ids. If T has a soft delete column, the rows are
+// only marked as deleted and can be brought back with Restore.
 func (o *SQliteStore[T, R]) DeleteMulti(ids []int64) error {
+	if o.softDelete == "" {
+		return o.Purge(ids)
+	}
+	placeholder, args := InArgs(ids)
+	now := time.Now()
+	query := fmt.Sprintf("UPDATE %s SET %s=?%s where %s IN (%s) and %s=0 RETURNING %s",
+		o.tablename, o.softDelete, o.touchAutoUpdate(), o.pk, placeholder, o.softDelete, o.pk)
+	_, err := o.write(store.ChangeDelete, func(tx *sql.Tx) ([]int64, error) {
+		deleted, err := queryIDs(tx, query, append(o.touchArgs(now.UnixMilli(), now), args...))
+		if err != nil {
+			return nil, fmt.Errorf("%s DeleteMulti exec failed: %w", o.tablename, err)
+		}
+		return deleted, nil
+	})
+	return err
+}
+
+// Restore brings back soft deleted rows with ids.
+func (o *SQliteStore[T, R]) Restore(ids []int64) error {
+	if o.softDelete == "" {
+		return fmt.Errorf("%s Restore: %w", o.tablename, store.ErrSoftDeleteUnsupported)
+	}
+	placeholder, args := InArgs(ids)
+	query := fmt.Sprintf("UPDATE %s SET %s=?%s where %s IN (%s) and %s<>0 RETURNING %s",
+		o.tablename, o.softDelete, o.touchAutoUpdate(), o.pk, placeholder, o.softDelete, o.pk)
+	_, err := o.write(store.ChangeRestore, func(tx *sql.Tx) ([]int64, error) {
+		restored, err := queryIDs(tx, query, append(o.touchArgs(0, time.Now()), args...))
+		if err != nil {
+			return nil, fmt.Errorf("%s Restore exec failed: %w", o.tablename, err)
+		}
+		return restored, nil
+	})
+	return err
+}
+
+// Purge permanently deletes the rows with ids, whether they are soft deleted or not.
+func (o *SQliteStore[T, R]) Purge(ids []int64) error {
+	placeholder, args := InArgs(ids)
+	query := fmt.Sprintf("DELETE from %s where %s IN (%s) RETURNING %s", o.tablename, o.pk, placeholder, o.pk)
+	_, err := o.write(store.ChangeDelete, func(tx *sql.Tx) ([]int64, error) {
+		purged, err := queryIDs(tx, query, args)
+		if err != nil {
+			return nil, fmt.Errorf("%s Purge exec failed: %w", o.tablename, err)
+		}
+		return purged, nil
+	})
+	return err
+}
+
+// write runs fn in a transaction while holding the store lock. The ids returned
+// by fn are recorded in the change log in the same transaction and published to
+// subscribers after the commit. If fn changed no rows, write returns store.ErrNotFound.
+func (o *SQliteStore[T, R]) write(op store.ChangeOp, fn func(tx *sql.Tx) ([]int64, error)) ([]int64, error) {
 	o.Lock()
 	defer o.Unlock()
-	placeholder, args := InArgs(ids)
-	query := fmt.Sprintf("DELETE from %s where %s IN (%s)", o.tablename, o.pk, placeholder)
-	res, err := o.db.Exec(query, args...)
+
+	tx, err := o.db.Begin()
 	if err != nil {
-		return fmt.Errorf("%s DeleteMulti exec failed: %w", o.tablename, err)
+		return nil, fmt.Errorf("%s %s begin failed: %w", o.tablename, op, err)
 	}
-	rowsAffected, err := res.RowsAffected()
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	ids, err := fn(tx)
 	if err != nil {
-		return fmt.Errorf("%s DeleteMulti RowsAffected failed: %w", o.tablename, err)
+		return nil, duplicateErr(err)
 	}
-	if rowsAffected == 0 {
-		return store.ErrNotFound
+	if len(ids) == 0 {
+		return nil, store.ErrNotFound
 	}
-	return nil
+
+	event, err := recordChange(tx, o.owner.changeLog, o.tablename, op, ids)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s fail to record change: %w", o.tablename, op, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s commit failed: %w", o.tablename, op, err)
+	}
+	o.feed.publish(event)
+	return ids, nil
+}
+
+// queryIDs runs a statement returning ids, such as one with a RETURNING clause.
+func queryIDs(tx *sql.Tx, query string, args []any) ([]int64, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
 }
 
 func (o *SQliteStore[T, R]) FindWhere(conds ...store.Cond) ([]T, error) {
+	return o.findWhere(o.liveCond(""), conds)
+}
+
+// FindDeleted is like FindWhere but only returns soft deleted rows.
+func (o *SQliteStore[T, R]) FindDeleted(conds ...store.Cond) ([]T, error) {
+	if o.softDelete == "" {
+		return nil, fmt.Errorf("%s FindDeleted: %w", o.tablename, store.ErrSoftDeleteUnsupported)
+	}
+	return o.findWhere(o.softDelete+"<>0", conds)
+}
+
+// FindFields is like FindWhere but only selects the given fields (db column names).
+// Only those fields are set in the returned objects, the others are left zero.
+// Fields are scanned directly into the struct without calling ScanRow, so JSON
+// columns that are not selected are never decoded.
+func (o *SQliteStore[T, R]) FindFields(fields []string, conds ...store.Cond) ([]T, error) {
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%s FindFields: no fields given", o.tablename)
+	}
+	cols := make([]column, 0, len(fields))
+	for _, field := range fields {
+		col, ok := o.column(field)
+		if !ok {
+			return nil, fmt.Errorf("%s FindFields: unknown field %q", o.tablename, field)
+		}
+		cols = append(cols, col)
+	}
+
+	o.RLock()
+	defer o.RUnlock()
+	whereStmt, args := whereClause(o.liveCond(""), conds)
+	findQuery := fmt.Sprintf("SELECT %s from %s%s", strings.Join(fields, ","), o.tablename, whereStmt)
+	rows, err := o.db.Query(findQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s FindFields Query error: %w", o.tablename, err)
+	}
+	defer rows.Close()
+
+	var objs []T
+	for rows.Next() {
+		var obj T
+		err = scanFields(reflect.ValueOf(&obj).Elem(), cols, rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s FindFields row.Scan error: %w", o.tablename, err)
+		}
+		objs = append(objs, obj)
+	}
+	return objs, nil
+}
+
+// findWhere runs a select of all columns filtered by conds and by scope, an
+// extra condition ANDed with conds. An empty scope selects all rows.
+func (o *SQliteStore[T, R]) findWhere(scope string, conds []store.Cond) ([]T, error) {
 	o.RLock()
 	defer o.RUnlock()
 	columnNames := make([]string, 0, len(o.columns))
 	for _, col := range o.columns {
 		columnNames = append(columnNames, col.Name)
 	}
-	whereStmt := ""
-	stmts := make([]string, 0, len(conds))
-	args := make([]any, 0, len(conds))
-	for _, cond := range conds {
-		s, arg := cond.GetQueryWithArgs()
-		stmts = append(stmts, s)
-		args = append(args, arg...)
-	}
-	if len(stmts) > 0 {
-		whereStmt = " where " + strings.Join(stmts, " ")
-	}
+	whereStmt, args := whereClause(scope, conds)
 	findQuery := fmt.Sprintf("SELECT %s from %s%s", strings.Join(columnNames, ","), o.tablename, whereStmt)
 	rows, err := o.db.Query(findQuery, args...)
 	if err != nil {
@@ -278,8 +682,7 @@ func (o *SQliteStore[T, R]) FindWhere(conds ...store.Cond) ([]T, error) {
 	var objs []T
 	for rows.Next() {
 		var obj T
-		k := R(&obj)
-		err = k.ScanRow(rows)
+		err = o.codec.scanRow(&obj, rows)
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
 				return nil, store.ErrNotFound
@@ -291,6 +694,341 @@ func (o *SQliteStore[T, R]) FindWhere(conds ...store.Cond) ([]T, error) {
 	return objs, nil
 }
 
+// Iterate calls fn for every row matching conds, in primary key order, scanning
+// one row at a time. Returning store.ErrStopIteration from fn stops the
+// iteration early without error; any other error stops it and is returned.
+// Iterate does not hold the store lock while fn runs, so fn may write to the
+// store. The rows seen are a consistent snapshot taken when the scan starts.
+// On a database opened WithInMemory the shared cache locks the table while the
+// scan is open, so there all matching rows are read before fn is called.
+func (o *SQliteStore[T, R]) Iterate(fn func(obj T) error, conds ...store.Cond) error {
+	columnNames := make([]string, 0, len(o.columns))
+	for _, col := range o.columns {
+		columnNames = append(columnNames, col.Name)
+	}
+	whereStmt, args := whereClause(o.liveCond(""), conds)
+	iterQuery := fmt.Sprintf("SELECT %s from %s%s order by %s", strings.Join(columnNames, ","), o.tablename, whereStmt, o.pk)
+	rows, err := o.db.Query(iterQuery, args...)
+	if err != nil {
+		return fmt.Errorf("%s Iterate Query error: %w", o.tablename, err)
+	}
+	defer rows.Close()
+
+	call := func(obj T) (bool, error) {
+		err := fn(obj)
+		if errors.Is(err, store.ErrStopIteration) {
+			return true, nil
+		}
+		return err != nil, err
+	}
+
+	var buffered []T
+	for rows.Next() {
+		var obj T
+		err = o.codec.scanRow(&obj, rows)
+		if err != nil {
+			return fmt.Errorf("%s Iterate row.Scan error: %w", o.tablename, err)
+		}
+		if o.owner.inMemory {
+			buffered = append(buffered, obj)
+			continue
+		}
+		if stop, err := call(obj); stop {
+			return err
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return fmt.Errorf("%s Iterate rows error: %w", o.tablename, err)
+	}
+	_ = rows.Close()
+
+	for _, obj := range buffered {
+		if stop, err := call(obj); stop {
+			return err
+		}
+	}
+	return nil
+}
+
+// whereClause joins conds into a where clause, ANDed with scope, an extra
+// condition that is skipped when empty.
+func whereClause(scope string, conds []store.Cond) (string, []any) {
+	stmts := make([]string, 0, len(conds))
+	args := make([]any, 0, len(conds))
+	for _, cond := range conds {
+		s, arg := cond.GetQueryWithArgs()
+		stmts = append(stmts, s)
+		for _, a := range arg {
+			args = append(args, normalizeValue(a))
+		}
+	}
+	switch {
+	case len(stmts) > 0 && scope != "":
+		return " where (" + strings.Join(stmts, " ") + ") and " + scope, args
+	case len(stmts) > 0:
+		return " where " + strings.Join(stmts, " "), args
+	case scope != "":
+		return " where " + scope, args
+	default:
+		return "", args
+	}
+}
+
+// liveCond returns the condition excluding soft deleted rows prefixed by
+// joiner, or an empty string if T has no soft delete column.
+func (o *SQliteStore[T, R]) liveCond(joiner string) string {
+	if o.softDelete == "" {
+		return ""
+	}
+	return joiner + o.softDelete + "=0"
+}
+
+// writeValues returns the values of all non-PK columns of obj in column order.
+// The version and soft delete columns are maintained by the store and are skipped.
+// Autocreate and autoupdate columns are set to now; autocreate columns are only
+// written on insert.
+func (o *SQliteStore[T, R]) writeValues(obj *T, now time.Time, insert bool) []any {
+	values := make([]any, 0, len(o.columns))
+	fieldPtrs := o.codec.fieldsVals(obj)
+	now = now.UTC()
+	for _, col := range o.columns {
+		if col.IsPK || col.IsVersion || col.IsSoftDelete {
+			continue
+		}
+		if col.IsAutoCreate {
+			if insert {
+				values = append(values, now)
+			}
+			continue
+		}
+		if col.IsAutoUpdate {
+			values = append(values, now)
+			continue
+		}
+		values = append(values, normalizeValue(fieldPtrs[col.Index]))
+	}
+	return values
+}
+
+// touchAutoUpdate returns the SET fragment updating the autoupdate column, if any.
+func (o *SQliteStore[T, R]) touchAutoUpdate() string {
+	if o.autoUpdate == "" {
+		return ""
+	}
+	return ", " + o.autoUpdate + "=?"
+}
+
+// touchArgs returns the args for a SET of val followed by touchAutoUpdate.
+func (o *SQliteStore[T, R]) touchArgs(val any, now time.Time) []any {
+	if o.autoUpdate == "" {
+		return []any{val}
+	}
+	return []any{val, now.UTC()}
+}
+
+// addMissingColumns adds the columns missing from an existing table, so that
+// fields can be added to a model without migrating its data. Nullable columns
+// are added as NULL and all others as NOT NULL with the zero value of their
+// type as default, which is what existing rows then read back.
+func addMissingColumns(db *sql.DB, tableName string, columns []column) error {
+	rows, err := db.Query("SELECT name from pragma_table_info(?)", tableName)
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+
+	for _, col := range columns {
+		if existing[col.Name] {
+			continue
+		}
+		if col.IsPK {
+			return fmt.Errorf("%s: primary key column %s cannot be added to an existing table", tableName, col.Name)
+		}
+		stmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", tableName, col.Name, col.SqLiteType)
+		if !col.IsNullable {
+			stmt += " NOT NULL DEFAULT " + zeroDefault(col)
+		}
+		_, err = db.Exec(stmt)
+		if err != nil {
+			return fmt.Errorf("%s: fail to add column %s: %w", tableName, col.Name, err)
+		}
+	}
+	return nil
+}
+
+// zeroDefault returns the SQL literal of the zero value of col. Existing rows
+// get version 1, the version Insert starts at.
+func zeroDefault(col column) string {
+	switch {
+	case col.IsVersion:
+		return "1"
+	case col.IsJSON:
+		return "'null'"
+	}
+	switch col.SqLiteType {
+	case sqliteTypeInt, sqliteTypeReal:
+		return "0"
+	case sqliteTypeDatetime:
+		return "'0001-01-01 00:00:00+00:00'"
+	case sqliteTypeBlob:
+		return "X''"
+	default:
+		return "''"
+	}
+}
+
+// duplicateErr marks unique constraint violations as store.ErrDuplicate.
+func duplicateErr(err error) error {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+		return fmt.Errorf("%w: %w", store.ErrDuplicate, err)
+	}
+	return err
+}
+
+// dropLegacyIdx drops the single column indexes of tableName created under
+// names that were not qualified with the table name.
+func dropLegacyIdx(db *sql.DB, tableName string, indexes []Index) error {
+	for _, name := range legacyIdxNames(indexes) {
+		var exists int
+		err := db.QueryRow("SELECT 1 from sqlite_master where type='index' and name=? and tbl_name=?", name, tableName).Scan(&exists)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, err = db.Exec("DROP INDEX IF EXISTS " + name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropChangedIdx drops the indexes of tableName whose definition differs from
+// the one in indexes, such as a unique index that became partial, so that they
+// are created again with the new definition.
+func dropChangedIdx(db *sql.DB, tableName string, indexes []Index) error {
+	for _, idx := range indexes {
+		var stored string
+		err := db.QueryRow("SELECT sql from sqlite_master where type='index' and name=? and tbl_name=?", idx.Name, tableName).Scan(&stored)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if stored == indexSQL(tableName, idx) {
+			continue
+		}
+		_, err = db.Exec("DROP INDEX IF EXISTS " + idx.Name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createIdx creates the indexes of tableName that do not exist yet. A unique
+// index cannot be created over rows that already share a value, which is
+// reported with the index and its columns so that the rows can be fixed.
+func createIdx(db *sql.DB, tableName string, indexes []Index) error {
+	for _, idx := range indexes {
+		_, err := db.Exec(generateCreateIdxSQL(tableName, idx))
+		if err != nil {
+			if err = duplicateErr(err); errors.Is(err, store.ErrDuplicate) {
+				return fmt.Errorf("%s: fail to create unique index %s, rows share a value of (%s): %w",
+					tableName, idx.Name, strings.Join(idx.Columns, ", "), err)
+			}
+			return fmt.Errorf("%s: fail to create index %s: %w", tableName, idx.Name, err)
+		}
+	}
+	return nil
+}
+
+// conflictTarget returns the ON CONFLICT target of the unique index on the
+// single column keyField, including the condition of a partial index.
+func (o *SQliteStore[T, R]) conflictTarget(keyField string) (string, bool) {
+	for _, idx := range o.indexes {
+		if !idx.Unique || len(idx.Columns) != 1 || indexColumn(idx.Columns[0]) != keyField {
+			continue
+		}
+		if idx.Where != "" {
+			return fmt.Sprintf("(%s) WHERE %s", keyField, idx.Where), true
+		}
+		return "(" + keyField + ")", true
+	}
+	return "", false
+}
+
+func (o *SQliteStore[T, R]) column(name string) (column, bool) {
+	for _, col := range o.columns {
+		if col.Name == name {
+			return col, true
+		}
+	}
+	return column{}, false
+}
+
+// Subscribe returns a channel receiving the change events of this store's table
+// after they are committed, and a function to unsubscribe. A subscriber falling
+// more than buffer events behind has its channel closed and should catch up
+// with ChangesSince from the last Seq it received.
+func (o *SQliteStore[T, R]) Subscribe(buffer int) (<-chan store.ChangeEvent, func()) {
+	return o.feed.subscribe(o.tablename, buffer)
+}
+
+// ChangesSince returns the persisted change events of this store's table with a
+// sequence number greater than seq.
+func (o *SQliteStore[T, R]) ChangesSince(seq int64) ([]store.ChangeEvent, error) {
+	events, err := changesSince(o.db, o.owner.changeLog, o.tablename, seq)
+	if err != nil {
+		return nil, fmt.Errorf("%s ChangesSince failed: %w", o.tablename, err)
+	}
+	return events, nil
+}
+
+// LastSeq returns the sequence number of the latest persisted change event of
+// this store's table, or 0 if there is none. Comparing it with an earlier
+// value tells whether the table was written since, by any process.
+func (o *SQliteStore[T, R]) LastSeq() (int64, error) {
+	seq, err := lastSeq(o.db, o.owner.changeLog, o.tablename)
+	if err != nil {
+		return 0, fmt.Errorf("%s LastSeq failed: %w", o.tablename, err)
+	}
+	return seq, nil
+}
+
+// PruneChanges deletes the persisted change events of all tables up to and
+// including seq.
+func (o *SQliteStore[T, R]) PruneChanges(upTo int64) error {
+	err := pruneChanges(o.db, o.owner.changeLog, upTo)
+	if err != nil {
+		return fmt.Errorf("%s PruneChanges failed: %w", o.tablename, err)
+	}
+	return nil
+}
+
+// Close releases the prepared statements of the store. The database is closed as
+// well if the store was created with NewStore.
 func (o *SQliteStore[T, R]) Close() error {
-	return o.db.Close()
+	for _, stmt := range []*sql.Stmt{o.getOneStmt, o.insertStmt, o.updateStmt, o.getAllStmt} {
+		_ = stmt.Close()
+	}
+	if o.ownsDB {
+		return o.owner.Close()
+	}
+	return nil
 }
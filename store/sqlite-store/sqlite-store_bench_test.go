@@ -1,91 +1,32 @@
 package sqlitestore
 
 import (
-	"context"
+	"fmt"
 	"os"
 	"testing"
-	"time"
 )
 
-// func BenchmarkReflectGetOne(b *testing.B) {
-// 	path := "./rbac.db"
-// 	roleStore, err := NewStoreReflect[Role](path)
-// 	if err != nil {
-// 		b.Fatalf("fail to create roleStore %v", err)
-// 	}
-
-// 	b.Cleanup(func() {
-// 		errRemove := os.Remove(path)
-// 		if errRemove != nil {
-// 			b.Fatalf("fail to clean up rbac.db. please clean up manually")
-// 		}
-// 	})
-
-// 	ctx := context.Background()
-// 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-// 	defer cancel()
-// 	err = roleStore.db.PingContext(ctx)
-// 	if err != nil {
-// 		b.Fatalf("ping fail %v", err)
-// 	}
-// 	role := Role{
-// 		Name:         "admin",
-// 		IsHuman:      true,
-// 		Permissions:  []int64{1, 2, 3},
-// 		Alias:        []string{"a", "b"},
-// 		Ages:         []int16{34, 22},
-// 		Prices:       []float32{4.5, 3.2},
-// 		Address:      Address{"street", "city", []string{"1", "2", "3"}},
-// 		AddressPtr:   &Address{"streetPtr", "cityPtr", []string{"4", "5", "6"}},
-// 		Addresses:    []Address{{"street1", "city1", []string{"7", "8", "9"}}, {"street2", "city2", []string{"10", "11", "12"}}},
-// 		AddressesPtr: []*Address{{"streetPtr1", "cityPtr1", []string{"13", "14", "15"}}, {"streetPtr2", "cityPtr2", []string{"16", "17", "18"}}},
-// 	}
-// 	id, err := roleStore.Insert(role)
-// 	if err != nil {
-// 		b.Fatalf("fail to insert: %v", err)
-// 	}
-
-// 	b.ResetTimer()
-// 	var r Role
-// 	for i := 0; i < b.N; i++ {
-// 		r, err = roleStore.GetOne(id)
-// 		if err != nil {
-// 			b.Fatalf("fail to get %d: %s", id, err)
-// 		}
-// 	}
-// 	_ = r
-// }
-
-func BenchmarkJsonGetOne(b *testing.B) {
-	path := "rbac2.db"
-	roleStore, err := NewStore[Role](path)
+// newBenchStore opens a role store at path with open, NewStore[Role] or
+// NewReflectStore[Role], and removes the database when b ends.
+func newBenchStore(b *testing.B, path string, open func(path string, opts ...Option) (*SQliteStore[Role, *Role], error)) *SQliteStore[Role, *Role] {
+	roleStore, err := open(path)
 	if err != nil {
 		b.Fatalf("fail to create roleStore %v", err)
 	}
-
 	b.Cleanup(func() {
+		_ = roleStore.Close()
 		errRemove := os.Remove(path)
 		if errRemove != nil {
-			b.Fatalf("fail to clean up rbac.db. please clean up manually")
-		}
-		errRemove = os.Remove(path + "-shm")
-		if errRemove != nil {
-			b.Fatalf("fail to clean up rbac.db. please clean up manually")
-		}
-		errRemove = os.Remove(path + "-wal")
-		if errRemove != nil {
-			b.Fatalf("fail to clean up rbac.db. please clean up manually")
+			b.Fatalf("fail to clean up %s. please clean up manually", path)
 		}
+		_ = os.Remove(path + "-shm")
+		_ = os.Remove(path + "-wal")
 	})
+	return roleStore
+}
 
-	ctx := context.Background()
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
-	err = roleStore.db.PingContext(ctx)
-	if err != nil {
-		b.Fatalf("ping fail %v", err)
-	}
-	role := Role{
+func benchRole() Role {
+	return Role{
 		Name:         "admin",
 		IsHuman:      true,
 		Permissions:  []int64{1, 2, 3},
@@ -97,13 +38,15 @@ func BenchmarkJsonGetOne(b *testing.B) {
 		Addresses:    []Address{{"street1", "city1", []string{"7", "8", "9"}}, {"street2", "city2", []string{"10", "11", "12"}}},
 		AddressesPtr: []*Address{{"streetPtr1", "cityPtr1", []string{"13", "14", "15"}}, {"streetPtr2", "cityPtr2", []string{"16", "17", "18"}}},
 	}
-	id, err := roleStore.Insert(role)
+}
+
+func benchGetOne(b *testing.B, roleStore *SQliteStore[Role, *Role]) {
+	id, err := roleStore.Insert(benchRole())
 	if err != nil {
 		b.Fatalf("fail to insert: %v", err)
 	}
 
 	b.ResetTimer()
-
 	var r Role
 	for i := 0; i < b.N; i++ {
 		r, err = roleStore.GetOne(id)
@@ -113,3 +56,94 @@ func BenchmarkJsonGetOne(b *testing.B) {
 	}
 	_ = r
 }
+
+// The Reflect benchmarks run the same model through NewReflectStore instead of
+// the hand-written FieldsVals and ScanRow of Role.
+
+func BenchmarkJsonGetOne(b *testing.B) {
+	benchGetOne(b, newBenchStore(b, "rbac_bench_json_get.db", NewStore[Role, *Role]))
+}
+
+func BenchmarkReflectGetOne(b *testing.B) {
+	benchGetOne(b, newBenchStore(b, "rbac_bench_reflect_get.db", NewReflectStore[Role]))
+}
+
+func benchFindWhere(b *testing.B, roleStore *SQliteStore[Role, *Role]) {
+	_, err := roleStore.InsertMulti(benchRoles(benchBatchSize, "find"))
+	if err != nil {
+		b.Fatalf("fail to insert multi: %s", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		roles, err := roleStore.FindWhere()
+		if err != nil || len(roles) != benchBatchSize {
+			b.Fatalf("fail to find: %d %v", len(roles), err)
+		}
+	}
+}
+
+func BenchmarkFindWhere(b *testing.B) {
+	benchFindWhere(b, newBenchStore(b, "rbac_bench_find.db", NewStore[Role, *Role]))
+}
+
+func BenchmarkReflectFindWhere(b *testing.B) {
+	benchFindWhere(b, newBenchStore(b, "rbac_bench_reflect_find.db", NewReflectStore[Role]))
+}
+
+func benchRoles(n int, prefix string) []Role {
+	roles := make([]Role, 0, n)
+	for i := 0; i < n; i++ {
+		roles = append(roles, Role{
+			Name:        fmt.Sprintf("%s %d", prefix, i),
+			IsHuman:     i%2 == 0,
+			Permissions: []int64{int64(i), int64(i + 1)},
+		})
+	}
+	return roles
+}
+
+const benchBatchSize = 100
+
+func BenchmarkInsert(b *testing.B) {
+	roleStore := newBenchStore(b, "rbac_bench_insert.db", NewStore[Role, *Role])
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, role := range benchRoles(benchBatchSize, fmt.Sprintf("insert %d", i)) {
+			_, err := roleStore.Insert(role)
+			if err != nil {
+				b.Fatalf("fail to insert: %s", err)
+			}
+		}
+	}
+}
+
+func benchInsertMulti(b *testing.B, roleStore *SQliteStore[Role, *Role]) {
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := roleStore.InsertMulti(benchRoles(benchBatchSize, fmt.Sprintf("insert %d", i)))
+		if err != nil {
+			b.Fatalf("fail to insert multi: %s", err)
+		}
+	}
+}
+
+func BenchmarkInsertMulti(b *testing.B) {
+	benchInsertMulti(b, newBenchStore(b, "rbac_bench_insert_multi.db", NewStore[Role, *Role]))
+}
+
+func BenchmarkReflectInsertMulti(b *testing.B) {
+	benchInsertMulti(b, newBenchStore(b, "rbac_bench_reflect_insert.db", NewReflectStore[Role]))
+}
+
+func BenchmarkUpsert(b *testing.B) {
+	roleStore := newBenchStore(b, "rbac_bench_upsert.db", NewStore[Role, *Role])
+	roles := benchRoles(benchBatchSize, "upsert")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := roleStore.Upsert(roles, "name")
+		if err != nil {
+			b.Fatalf("fail to upsert: %s", err)
+		}
+	}
+}
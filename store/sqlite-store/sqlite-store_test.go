@@ -2,14 +2,18 @@ package sqlitestore
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"os"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/yinloo-ola/srbac/models"
 	"github.com/yinloo-ola/srbac/store"
 )
 
@@ -258,3 +262,1275 @@ func TestNew(t *testing.T) {
 	}
 
 }
+
+func TestNewStoreValidatesRow(t *testing.T) {
+	path := "rbac_validate.db"
+	t.Cleanup(func() {
+		_ = os.Remove(path)
+		_ = os.Remove(path + "-shm")
+		_ = os.Remove(path + "-wal")
+	})
+
+	_, err := NewStore[Misordered](path)
+	if err == nil || !strings.Contains(err.Error(), "FieldsVals") {
+		t.Fatalf("expected FieldsVals order error but got %v", err)
+	}
+
+	_, err = NewStore[MisScanned](path)
+	if err == nil || !strings.Contains(err.Error(), "ScanRow") {
+		t.Fatalf("expected ScanRow order error but got %v", err)
+	}
+}
+
+func TestInsertMultiAndUpsert(t *testing.T) {
+	path := "rbac_insert_multi.db"
+	roleStore, err := NewStore[Role](path)
+	if err != nil {
+		t.Fatalf("fail to create roleStore %v", err)
+	}
+	t.Cleanup(func() {
+		_ = roleStore.Close()
+		_ = os.Remove(path)
+		_ = os.Remove(path + "-shm")
+		_ = os.Remove(path + "-wal")
+	})
+
+	roles := []Role{
+		{Name: "admin", IsHuman: true, Permissions: []int64{1, 2}},
+		{Name: "referee", Permissions: []int64{3}},
+	}
+	ids, err := roleStore.InsertMulti(roles)
+	if err != nil {
+		t.Fatalf("InsertMulti failed: %v", err)
+	}
+	assert.Equal(t, []int64{1, 2}, ids)
+
+	_, err = roleStore.InsertMulti([]Role{{Name: "guest"}, {Name: "admin"}})
+	if err == nil {
+		t.Fatalf("InsertMulti should fail on duplicate name")
+	}
+	all, err := roleStore.FindWhere()
+	if err != nil {
+		t.Fatalf("FindWhere failed: %v", err)
+	}
+	assert.Len(t, all, 2, "failed InsertMulti must not leave partial rows")
+
+	upserts := []Role{
+		{Name: "referee", Permissions: []int64{3, 4}},
+		{Name: "guest", Permissions: []int64{5}},
+	}
+	ids, err = roleStore.Upsert(upserts, "name")
+	if err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+	assert.Equal(t, int64(2), ids[0])
+	assert.Equal(t, int64(3), ids[1])
+
+	referee, err := roleStore.GetOne(2)
+	if err != nil {
+		t.Fatalf("GetOne failed: %v", err)
+	}
+	assert.Equal(t, []int64{3, 4}, referee.Permissions)
+
+	_, err = roleStore.Upsert(upserts, "isHuman")
+	if err == nil {
+		t.Fatalf("Upsert should reject a non unique column")
+	}
+}
+
+func TestUpdateFields(t *testing.T) {
+	path := "rbac_update_fields.db"
+	roleStore, err := NewStore[Role](path)
+	if err != nil {
+		t.Fatalf("fail to create roleStore %v", err)
+	}
+	t.Cleanup(func() {
+		_ = roleStore.Close()
+		_ = os.Remove(path)
+		_ = os.Remove(path + "-shm")
+		_ = os.Remove(path + "-wal")
+	})
+
+	id, err := roleStore.Insert(Role{Name: "admin", Permissions: []int64{1, 2}})
+	if err != nil {
+		t.Fatalf("fail to insert: %v", err)
+	}
+
+	// two editors start from the same snapshot and patch different columns
+	edit1 := Role{Name: "super_admin"}
+	edit2 := Role{IsHuman: true, Permissions: []int64{3}}
+	err = roleStore.UpdateFields(id, edit1, "name")
+	if err != nil {
+		t.Fatalf("UpdateFields failed: %v", err)
+	}
+	err = roleStore.UpdateFields(id, edit2, "isHuman", "permissions")
+	if err != nil {
+		t.Fatalf("UpdateFields failed: %v", err)
+	}
+
+	role, err := roleStore.GetOne(id)
+	if err != nil {
+		t.Fatalf("GetOne failed: %v", err)
+	}
+	assert.Equal(t, "super_admin", role.Name)
+	assert.True(t, role.IsHuman)
+	assert.Equal(t, []int64{3}, role.Permissions)
+
+	err = roleStore.UpdateFields(100, edit1, "name")
+	if !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected not found but gotten %v", err)
+	}
+	err = roleStore.UpdateFields(id, edit1, "unknown")
+	if err == nil {
+		t.Fatalf("expected unknown field error")
+	}
+	err = roleStore.UpdateFields(id, edit1, "id")
+	if err == nil {
+		t.Fatalf("expected primary key error")
+	}
+}
+
+func TestUpdateVersionConflict(t *testing.T) {
+	path := "rbac_version.db"
+	versionedStore, err := NewStore[Versioned](path)
+	if err != nil {
+		t.Fatalf("fail to create versionedStore %v", err)
+	}
+	t.Cleanup(func() {
+		_ = versionedStore.Close()
+		_ = os.Remove(path)
+		_ = os.Remove(path + "-shm")
+		_ = os.Remove(path + "-wal")
+	})
+
+	id, err := versionedStore.Insert(Versioned{Name: "admin", Version: 42})
+	if err != nil {
+		t.Fatalf("fail to insert: %v", err)
+	}
+	obj, err := versionedStore.GetOne(id)
+	if err != nil {
+		t.Fatalf("GetOne failed: %v", err)
+	}
+	assert.Equal(t, int64(1), obj.Version, "insert must start at version 1")
+
+	// both editors read version 1, the second write must be rejected
+	edit1, edit2 := obj, obj
+	edit1.Name = "editor 1"
+	edit2.Name = "editor 2"
+	err = versionedStore.Update(id, edit1)
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	err = versionedStore.Update(id, edit2)
+	if !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("expected conflict but gotten %v", err)
+	}
+
+	obj, err = versionedStore.GetOne(id)
+	if err != nil {
+		t.Fatalf("GetOne failed: %v", err)
+	}
+	assert.Equal(t, "editor 1", obj.Name)
+	assert.Equal(t, int64(2), obj.Version)
+
+	err = versionedStore.UpdateFields(id, Versioned{Name: "stale", Version: 1}, "name", "version")
+	if !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("expected conflict but gotten %v", err)
+	}
+	err = versionedStore.UpdateFields(id, Versioned{Name: "patched"}, "name")
+	if err != nil {
+		t.Fatalf("UpdateFields failed: %v", err)
+	}
+	obj, err = versionedStore.GetOne(id)
+	if err != nil {
+		t.Fatalf("GetOne failed: %v", err)
+	}
+	assert.Equal(t, "patched", obj.Name)
+	assert.Equal(t, int64(3), obj.Version)
+
+	err = versionedStore.Update(100, obj)
+	if !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected not found but gotten %v", err)
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	path := "rbac_soft_delete.db"
+	softStore, err := NewStore[SoftDeleted](path)
+	if err != nil {
+		t.Fatalf("fail to create softStore %v", err)
+	}
+	t.Cleanup(func() {
+		_ = softStore.Close()
+		_ = os.Remove(path)
+		_ = os.Remove(path + "-shm")
+		_ = os.Remove(path + "-wal")
+	})
+
+	ids, err := softStore.InsertMulti([]SoftDeleted{{Name: "admin"}, {Name: "guest"}, {Name: "referee"}})
+	if err != nil {
+		t.Fatalf("InsertMulti failed: %v", err)
+	}
+
+	err = softStore.DeleteMulti([]int64{ids[0], ids[1]})
+	if err != nil {
+		t.Fatalf("DeleteMulti failed: %v", err)
+	}
+	err = softStore.DeleteMulti([]int64{ids[0]})
+	if !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("deleting a deleted row should be not found but gotten %v", err)
+	}
+
+	_, err = softStore.GetOne(ids[0])
+	if !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("GetOne should not return deleted rows but gotten %v", err)
+	}
+	objs, err := softStore.GetMulti(ids)
+	if err != nil {
+		t.Fatalf("GetMulti failed: %v", err)
+	}
+	assert.Equal(t, []SoftDeleted{{Id: ids[2], Name: "referee"}}, objs)
+	objs, err = softStore.FindWhere(
+		&store.WhereCond{Field: "name", Op: store.OpEqual, Val: "admin"},
+		store.QueryJoinerOr,
+		&store.WhereCond{Field: "name", Op: store.OpEqual, Val: "referee"},
+	)
+	if err != nil {
+		t.Fatalf("FindWhere failed: %v", err)
+	}
+	assert.Equal(t, []SoftDeleted{{Id: ids[2], Name: "referee"}}, objs)
+	err = softStore.Update(ids[0], SoftDeleted{Name: "renamed"})
+	if !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Update should not touch deleted rows but gotten %v", err)
+	}
+
+	deleted, err := softStore.FindDeleted()
+	if err != nil {
+		t.Fatalf("FindDeleted failed: %v", err)
+	}
+	assert.Len(t, deleted, 2)
+	for _, obj := range deleted {
+		assert.NotZero(t, obj.DeletedAt)
+	}
+
+	err = softStore.Restore([]int64{ids[0]})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	obj, err := softStore.GetOne(ids[0])
+	if err != nil {
+		t.Fatalf("GetOne after Restore failed: %v", err)
+	}
+	assert.Equal(t, SoftDeleted{Id: ids[0], Name: "admin"}, obj)
+	err = softStore.Restore([]int64{ids[2]})
+	if !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("restoring a live row should be not found but gotten %v", err)
+	}
+
+	err = softStore.Purge([]int64{ids[1]})
+	if err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+	err = softStore.Restore([]int64{ids[1]})
+	if !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("restoring a purged row should be not found but gotten %v", err)
+	}
+
+	roleStore, err := NewStore[Role](path)
+	if err != nil {
+		t.Fatalf("fail to create roleStore %v", err)
+	}
+	defer roleStore.Close()
+	err = roleStore.Restore([]int64{1})
+	if !errors.Is(err, store.ErrSoftDeleteUnsupported) {
+		t.Fatalf("expected soft delete unsupported but gotten %v", err)
+	}
+}
+
+func TestTimestamps(t *testing.T) {
+	path := "rbac_timestamps.db"
+	tsStore, err := NewStore[Timestamped](path)
+	if err != nil {
+		t.Fatalf("fail to create tsStore %v", err)
+	}
+	t.Cleanup(func() {
+		_ = tsStore.Close()
+		_ = os.Remove(path)
+		_ = os.Remove(path + "-shm")
+		_ = os.Remove(path + "-wal")
+	})
+
+	lastSeen := time.Date(2023, 5, 1, 10, 30, 0, 123456789, time.FixedZone("UTC+8", 8*3600))
+	before := time.Now()
+	id, err := tsStore.Insert(Timestamped{Name: "admin", LastSeen: lastSeen})
+	if err != nil {
+		t.Fatalf("fail to insert: %v", err)
+	}
+	created, err := tsStore.GetOne(id)
+	if err != nil {
+		t.Fatalf("GetOne failed: %v", err)
+	}
+	assert.True(t, created.LastSeen.Equal(lastSeen), "time.Time column must round trip")
+	assert.False(t, created.CreatedAt.Before(before.Truncate(time.Microsecond)))
+	assert.True(t, created.CreatedAt.Equal(created.UpdatedAt))
+
+	time.Sleep(2 * time.Millisecond)
+	created.Name = "super_admin"
+	err = tsStore.Update(id, created)
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	updated, err := tsStore.GetOne(id)
+	if err != nil {
+		t.Fatalf("GetOne failed: %v", err)
+	}
+	assert.True(t, updated.CreatedAt.Equal(created.CreatedAt), "Update must keep created_at")
+	assert.True(t, updated.UpdatedAt.After(created.UpdatedAt), "Update must bump updated_at")
+
+	time.Sleep(2 * time.Millisecond)
+	_, err = tsStore.Upsert([]Timestamped{{Name: "super_admin"}}, "name")
+	if err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+	upserted, err := tsStore.GetOne(id)
+	if err != nil {
+		t.Fatalf("GetOne failed: %v", err)
+	}
+	assert.True(t, upserted.CreatedAt.Equal(created.CreatedAt), "Upsert must keep created_at")
+	assert.True(t, upserted.UpdatedAt.After(updated.UpdatedAt), "Upsert must bump updated_at")
+
+	later := &store.WhereCond{Field: "last_seen", Op: store.OpGt, Val: lastSeen.Add(-time.Second)}
+	found, err := tsStore.FindWhere(later)
+	if err != nil {
+		t.Fatalf("FindWhere failed: %v", err)
+	}
+	assert.Len(t, found, 0, "Upsert overwrote last_seen with a zero time")
+
+	err = tsStore.UpdateFields(id, Timestamped{LastSeen: lastSeen}, "last_seen")
+	if err != nil {
+		t.Fatalf("UpdateFields failed: %v", err)
+	}
+	found, err = tsStore.FindWhere(later)
+	if err != nil {
+		t.Fatalf("FindWhere failed: %v", err)
+	}
+	assert.Len(t, found, 1)
+
+	err = tsStore.UpdateFields(id, Timestamped{}, "created_at")
+	if err == nil {
+		t.Fatalf("UpdateFields should reject autocreate columns")
+	}
+}
+
+func TestIterate(t *testing.T) {
+	path := "rbac_iterate.db"
+	roleStore, err := NewStore[Role](path)
+	if err != nil {
+		t.Fatalf("fail to create roleStore %v", err)
+	}
+	t.Cleanup(func() {
+		_ = roleStore.Close()
+		_ = os.Remove(path)
+		_ = os.Remove(path + "-shm")
+		_ = os.Remove(path + "-wal")
+	})
+
+	roles := make([]Role, 0, 10)
+	for i := 0; i < 10; i++ {
+		roles = append(roles, Role{Name: fmt.Sprintf("role %d", i), IsHuman: i%2 == 0})
+	}
+	_, err = roleStore.InsertMulti(roles)
+	if err != nil {
+		t.Fatalf("InsertMulti failed: %v", err)
+	}
+
+	var ids []int64
+	err = roleStore.Iterate(func(role Role) error {
+		ids = append(ids, role.Id)
+		return nil
+	}, &store.WhereCond{Field: "isHuman", Op: store.OpEqual, Val: true})
+	if err != nil {
+		t.Fatalf("Iterate failed: %v", err)
+	}
+	assert.Equal(t, []int64{1, 3, 5, 7, 9}, ids)
+
+	ids = nil
+	err = roleStore.Iterate(func(role Role) error {
+		ids = append(ids, role.Id)
+		if len(ids) == 3 {
+			return store.ErrStopIteration
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Iterate with early stop failed: %v", err)
+	}
+	assert.Equal(t, []int64{1, 2, 3}, ids)
+
+	errBoom := errors.New("boom")
+	err = roleStore.Iterate(func(role Role) error {
+		return errBoom
+	})
+	if !errors.Is(err, errBoom) {
+		t.Fatalf("expected callback error but gotten %v", err)
+	}
+
+	// the store stays writable from within the callback
+	err = roleStore.Iterate(func(role Role) error {
+		role.Name += " renamed"
+		return roleStore.Update(role.Id, role)
+	})
+	if err != nil {
+		t.Fatalf("Iterate with writes failed: %v", err)
+	}
+	role, err := roleStore.GetOne(10)
+	if err != nil {
+		t.Fatalf("GetOne failed: %v", err)
+	}
+	assert.Equal(t, "role 9 renamed", role.Name)
+}
+
+func TestIterateInMemory(t *testing.T) {
+	roleStore, err := NewStore[Role]("rbac_iterate_mem", WithInMemory())
+	if err != nil {
+		t.Fatalf("fail to create in-memory roleStore %v", err)
+	}
+	t.Cleanup(func() {
+		_ = roleStore.Close()
+	})
+	_, err = roleStore.InsertMulti([]Role{{Name: "admin"}, {Name: "guest"}})
+	if err != nil {
+		t.Fatalf("InsertMulti failed: %v", err)
+	}
+
+	// the shared cache locks the table while a cursor is open, so the rows
+	// are read before fn is called
+	done := make(chan error, 1)
+	go func() {
+		done <- roleStore.Iterate(func(role Role) error {
+			role.Name += " renamed"
+			err := roleStore.Update(role.Id, role)
+			if err != nil {
+				return err
+			}
+			_, err = roleStore.Insert(Role{Name: role.Name + " copy"})
+			return err
+		})
+	}()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatalf("Iterate with writes failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Iterate with writes blocked on an in-memory database")
+	}
+
+	roles, err := roleStore.FindWhere()
+	if err != nil {
+		t.Fatalf("FindWhere failed: %v", err)
+	}
+	names := make([]string, 0, len(roles))
+	for _, r := range roles {
+		names = append(names, r.Name)
+	}
+	assert.Equal(t, []string{"admin renamed", "guest renamed", "admin renamed copy", "guest renamed copy"}, names)
+}
+
+func TestFindFields(t *testing.T) {
+	path := "rbac_find_fields.db"
+	roleStore, err := NewStore[Role](path)
+	if err != nil {
+		t.Fatalf("fail to create roleStore %v", err)
+	}
+	t.Cleanup(func() {
+		_ = roleStore.Close()
+		_ = os.Remove(path)
+		_ = os.Remove(path + "-shm")
+		_ = os.Remove(path + "-wal")
+	})
+
+	_, err = roleStore.InsertMulti([]Role{
+		{Name: "admin", IsHuman: true, Permissions: []int64{1, 2}, Address: Address{Street: "street"}},
+		{Name: "referee", Permissions: []int64{3}},
+	})
+	if err != nil {
+		t.Fatalf("InsertMulti failed: %v", err)
+	}
+
+	roles, err := roleStore.FindFields([]string{"id", "name", "isHuman"})
+	if err != nil {
+		t.Fatalf("FindFields failed: %v", err)
+	}
+	assert.Equal(t, []Role{{Id: 1, Name: "admin", IsHuman: true}, {Id: 2, Name: "referee"}}, roles)
+
+	roles, err = roleStore.FindFields([]string{"permissions"}, &store.WhereCond{Field: "name", Op: store.OpEqual, Val: "admin"})
+	if err != nil {
+		t.Fatalf("FindFields failed: %v", err)
+	}
+	assert.Equal(t, []Role{{Permissions: []int64{1, 2}}}, roles)
+
+	_, err = roleStore.FindFields([]string{"id", "unknown"})
+	if err == nil {
+		t.Fatalf("FindFields should reject unknown fields")
+	}
+}
+
+func TestChangeFeed(t *testing.T) {
+	path := "rbac_changes.db"
+	roleStore, err := NewStore[Role](path)
+	if err != nil {
+		t.Fatalf("fail to create roleStore %v", err)
+	}
+	softStore, err := NewStore[SoftDeleted](path)
+	if err != nil {
+		t.Fatalf("fail to create softStore %v", err)
+	}
+	t.Cleanup(func() {
+		_ = roleStore.Close()
+		_ = softStore.Close()
+		_ = os.Remove(path)
+		_ = os.Remove(path + "-shm")
+		_ = os.Remove(path + "-wal")
+	})
+
+	events, cancel := roleStore.Subscribe(10)
+	defer cancel()
+
+	id, err := roleStore.Insert(Role{Name: "admin"})
+	if err != nil {
+		t.Fatalf("fail to insert: %v", err)
+	}
+	_, err = softStore.Insert(SoftDeleted{Name: "other table"})
+	if err != nil {
+		t.Fatalf("fail to insert: %v", err)
+	}
+	err = roleStore.Update(id, Role{Name: "super_admin"})
+	if err != nil {
+		t.Fatalf("fail to update: %v", err)
+	}
+	err = roleStore.Update(100, Role{Name: "missing"})
+	if !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected not found but gotten %v", err)
+	}
+	err = roleStore.DeleteMulti([]int64{id, 100})
+	if err != nil {
+		t.Fatalf("fail to delete: %v", err)
+	}
+
+	var got []store.ChangeEvent
+	for i := 0; i < 3; i++ {
+		got = append(got, <-events)
+	}
+	assert.Equal(t, store.ChangeInsert, got[0].Op)
+	assert.Equal(t, store.ChangeUpdate, got[1].Op)
+	assert.Equal(t, store.ChangeDelete, got[2].Op)
+	assert.Equal(t, []int64{id}, got[2].IDs, "only deleted ids are reported")
+	for _, event := range got {
+		assert.Equal(t, "role", event.Table)
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event %#v", event)
+	default:
+	}
+
+	logged, err := roleStore.ChangesSince(0)
+	if err != nil {
+		t.Fatalf("ChangesSince failed: %v", err)
+	}
+	assert.Equal(t, got, logged)
+	logged, err = roleStore.ChangesSince(got[0].Seq)
+	if err != nil {
+		t.Fatalf("ChangesSince failed: %v", err)
+	}
+	assert.Equal(t, got[1:], logged)
+	assert.Greater(t, got[2].Seq, got[1].Seq)
+	last, err := roleStore.LastSeq()
+	assert.NoError(t, err)
+	assert.Equal(t, got[2].Seq, last, "the change of the other table is not counted")
+	last, err = softStore.LastSeq()
+	assert.NoError(t, err)
+	assert.Less(t, last, got[2].Seq)
+
+	err = roleStore.PruneChanges(got[1].Seq)
+	if err != nil {
+		t.Fatalf("PruneChanges failed: %v", err)
+	}
+	logged, err = roleStore.ChangesSince(0)
+	if err != nil {
+		t.Fatalf("ChangesSince failed: %v", err)
+	}
+	assert.Equal(t, got[2:], logged)
+
+	// a subscriber that does not keep up is dropped
+	lagging, cancelLagging := roleStore.Subscribe(1)
+	defer cancelLagging()
+	_, err = roleStore.InsertMulti([]Role{{Name: "a"}})
+	if err != nil {
+		t.Fatalf("fail to insert: %v", err)
+	}
+	_, err = roleStore.InsertMulti([]Role{{Name: "b"}})
+	if err != nil {
+		t.Fatalf("fail to insert: %v", err)
+	}
+	<-lagging
+	_, ok := <-lagging
+	assert.False(t, ok, "lagging subscriber channel must be closed")
+}
+
+func TestSharedDB(t *testing.T) {
+	path := "rbac_shared.db"
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("fail to open db %v", err)
+	}
+	t.Cleanup(func() {
+		_ = os.Remove(path)
+		_ = os.Remove(path + "-shm")
+		_ = os.Remove(path + "-wal")
+	})
+	roleStore, err := NewStoreFromDB[Role](db)
+	if err != nil {
+		t.Fatalf("fail to create roleStore %v", err)
+	}
+	softStore, err := NewStoreFromDB[SoftDeleted](db)
+	if err != nil {
+		t.Fatalf("fail to create softStore %v", err)
+	}
+	assert.Same(t, roleStore.RWMutex, softStore.RWMutex)
+	assert.Same(t, roleStore.db, softStore.db)
+
+	events, cancel := db.Subscribe(200)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 200)
+	for i := 0; i < 100; i++ {
+		i := i
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := roleStore.Insert(Role{Name: fmt.Sprintf("role %d", i)})
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := softStore.Insert(SoftDeleted{Name: fmt.Sprintf("soft %d", i)})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("concurrent insert failed: %v", err)
+		}
+	}
+
+	tables := map[string]int{}
+	for i := 0; i < 200; i++ {
+		tables[(<-events).Table]++
+	}
+	assert.Equal(t, map[string]int{"role": 100, "soft_deleted": 100}, tables)
+
+	logged, err := db.ChangesSince(0)
+	if err != nil {
+		t.Fatalf("ChangesSince failed: %v", err)
+	}
+	assert.Len(t, logged, 200)
+
+	err = roleStore.Close()
+	if err != nil {
+		t.Fatalf("roleStore.Close failed: %v", err)
+	}
+	_, err = softStore.FindWhere()
+	if err != nil {
+		t.Fatalf("closing one store must not close the db: %v", err)
+	}
+	err = softStore.Close()
+	if err != nil {
+		t.Fatalf("softStore.Close failed: %v", err)
+	}
+	err = db.Close()
+	if err != nil {
+		t.Fatalf("db.Close failed: %v", err)
+	}
+	err = db.Close()
+	if err != nil {
+		t.Fatalf("second db.Close failed: %v", err)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	path := "rbac_options.db"
+	t.Cleanup(func() {
+		_ = os.Remove(path)
+		_ = os.Remove(path + "-shm")
+		_ = os.Remove(path + "-wal")
+	})
+
+	roleStore, err := NewStore[Role](path,
+		WithBusyTimeout(3*time.Second), WithForeignKeys(), WithCacheSize(4096),
+		WithMaxOpenConns(4), WithConnMaxLifetime(time.Minute))
+	if err != nil {
+		t.Fatalf("fail to create roleStore %v", err)
+	}
+	var busyTimeout, foreignKeys, cacheSize int
+	var journalMode string
+	err = roleStore.db.QueryRow("PRAGMA busy_timeout").Scan(&busyTimeout)
+	assert.NoError(t, err)
+	err = roleStore.db.QueryRow("PRAGMA foreign_keys").Scan(&foreignKeys)
+	assert.NoError(t, err)
+	err = roleStore.db.QueryRow("PRAGMA cache_size").Scan(&cacheSize)
+	assert.NoError(t, err)
+	err = roleStore.db.QueryRow("PRAGMA journal_mode").Scan(&journalMode)
+	assert.NoError(t, err)
+	assert.Equal(t, 3000, busyTimeout)
+	assert.Equal(t, 1, foreignKeys)
+	assert.Equal(t, -4096, cacheSize)
+	assert.Equal(t, "wal", journalMode)
+	assert.Equal(t, 4, roleStore.db.Stats().MaxOpenConnections)
+
+	id, err := roleStore.Insert(Role{Name: "admin"})
+	if err != nil {
+		t.Fatalf("fail to insert: %v", err)
+	}
+	err = roleStore.Close()
+	if err != nil {
+		t.Fatalf("fail to close: %v", err)
+	}
+
+	readOnlyStore, err := NewStore[Role](path, WithReadOnly())
+	if err != nil {
+		t.Fatalf("fail to create read-only roleStore %v", err)
+	}
+	defer readOnlyStore.Close()
+	role, err := readOnlyStore.GetOne(id)
+	if err != nil {
+		t.Fatalf("GetOne on read-only store failed: %v", err)
+	}
+	assert.Equal(t, "admin", role.Name)
+	_, err = readOnlyStore.Insert(Role{Name: "referee"})
+	if err == nil {
+		t.Fatalf("insert into read-only store should fail")
+	}
+
+	memStore, err := NewStore[Role]("rbac_options_mem", WithInMemory())
+	if err != nil {
+		t.Fatalf("fail to create in-memory roleStore %v", err)
+	}
+	defer memStore.Close()
+	_, err = memStore.Insert(Role{Name: "admin"})
+	if err != nil {
+		t.Fatalf("fail to insert in memory: %v", err)
+	}
+	sameMemStore, err := NewStore[Role]("rbac_options_mem", WithInMemory())
+	if err != nil {
+		t.Fatalf("fail to create second in-memory roleStore %v", err)
+	}
+	defer sameMemStore.Close()
+	roles, err := sameMemStore.FindWhere()
+	if err != nil {
+		t.Fatalf("FindWhere in memory failed: %v", err)
+	}
+	assert.Len(t, roles, 1, "stores opened with the same in-memory name share the database")
+	_, err = os.Stat("rbac_options_mem")
+	assert.True(t, os.IsNotExist(err), "in-memory store must not create a file")
+}
+
+func TestTableNames(t *testing.T) {
+	path := "rbac_table_names.db"
+	db, err := Open(path, WithTablePrefix("svc_"))
+	if err != nil {
+		t.Fatalf("fail to open db %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+		_ = os.Remove(path)
+		_ = os.Remove(path + "-shm")
+		_ = os.Remove(path + "-wal")
+	})
+
+	// the test Role and models.Role would both map to table "role"
+	testRoleStore, err := NewStoreFromDB[Role](db)
+	if err != nil {
+		t.Fatalf("fail to create testRoleStore %v", err)
+	}
+	modelRoleStore, err := NewStoreFromDB[models.Role](db, WithTableName("rbac_role"))
+	if err != nil {
+		t.Fatalf("fail to create modelRoleStore %v", err)
+	}
+	namedStore, err := NewStoreFromDB[Named](db)
+	if err != nil {
+		t.Fatalf("fail to create namedStore %v", err)
+	}
+	unprefixedStore, err := NewStoreFromDB[Named](db, WithTablePrefix(""), WithTableName("plain"))
+	if err != nil {
+		t.Fatalf("fail to create unprefixedStore %v", err)
+	}
+	assert.Equal(t, "svc_role", testRoleStore.tablename)
+	assert.Equal(t, "svc_rbac_role", modelRoleStore.tablename)
+	assert.Equal(t, "svc_custom_named", namedStore.tablename)
+	assert.Equal(t, "plain", unprefixedStore.tablename)
+
+	_, err = testRoleStore.Insert(Role{Name: "admin"})
+	if err != nil {
+		t.Fatalf("fail to insert test role: %v", err)
+	}
+	_, err = modelRoleStore.Insert(models.Role{Name: "admin", Permissions: []int64{1}})
+	if err != nil {
+		t.Fatalf("fail to insert model role: %v", err)
+	}
+
+	var tables []string
+	rows, err := db.db.Query("SELECT name from sqlite_master where type='table' and name not like 'sqlite_%' order by name")
+	if err != nil {
+		t.Fatalf("fail to list tables: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		assert.NoError(t, rows.Scan(&name))
+		tables = append(tables, name)
+	}
+	assert.Equal(t, []string{"plain", "svc_custom_named", "svc_rbac_role", "svc_role", "svc_srbac_changelog"}, tables)
+
+	_, err = NewStoreFromDB[Named](db, WithTableName("bad name; drop table svc_role"))
+	if err == nil {
+		t.Fatalf("invalid table names must be rejected")
+	}
+}
+
+func TestIndexes(t *testing.T) {
+	path := "rbac_indexes.db"
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("fail to open db %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+		_ = os.Remove(path)
+		_ = os.Remove(path + "-shm")
+		_ = os.Remove(path + "-wal")
+	})
+
+	// indexes created before names were qualified with the table name
+	_, err = db.db.Exec("CREATE TABLE membership (id INTEGER PRIMARY KEY, tenant_id INTEGER, user_id TEXT, role TEXT, active INTEGER); CREATE INDEX idx_user_id ON membership (user_id asc)")
+	if err != nil {
+		t.Fatalf("fail to create legacy table: %v", err)
+	}
+
+	membershipStore, err := NewStoreFromDB[Membership](db)
+	if err != nil {
+		t.Fatalf("fail to create membershipStore %v", err)
+	}
+	// a second table with a user_id index must not collide
+	_, err = NewStoreFromDB[models.User](db)
+	if err != nil {
+		t.Fatalf("fail to create userStore %v", err)
+	}
+
+	indexes := map[string]string{}
+	rows, err := db.db.Query("SELECT name, tbl_name from sqlite_master where type='index' and sql is not null")
+	if err != nil {
+		t.Fatalf("fail to list indexes: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, table string
+		assert.NoError(t, rows.Scan(&name, &table))
+		indexes[name] = table
+	}
+	assert.Equal(t, map[string]string{
+		"idx_membership_user_id":     "membership",
+		"idx_membership_tenant_user": "membership",
+		"idx_membership_active_role": "membership",
+		"idx_user_user_id":           "user",
+		"idx_srbac_changelog_tbl":    "srbac_changelog",
+	}, indexes)
+
+	_, err = membershipStore.Insert(Membership{TenantID: 1, UserID: "alice", Role: "admin", Active: true})
+	assert.NoError(t, err)
+	_, err = membershipStore.Insert(Membership{TenantID: 2, UserID: "alice", Role: "admin", Active: true})
+	assert.NoError(t, err)
+	_, err = membershipStore.Insert(Membership{TenantID: 1, UserID: "alice", Role: "viewer"})
+	if err == nil {
+		t.Fatalf("duplicate (tenant_id, user_id) must be rejected")
+	}
+
+	var plan string
+	err = db.db.QueryRow("EXPLAIN QUERY PLAN SELECT id from membership where role = 'admin' and active = 1").Scan(new(int), new(int), new(int), &plan)
+	assert.NoError(t, err)
+	assert.Contains(t, plan, "idx_membership_active_role")
+
+	_, err = getIndexes("membership", getColumns(reflect.TypeOf(Membership{})), []Index{{Name: "bad", Columns: []string{"missing"}}})
+	assert.Error(t, err)
+	_, err = getIndexes("membership", getColumns(reflect.TypeOf(Membership{})), []Index{{Name: "user_id", Columns: []string{"role"}}})
+	assert.Error(t, err)
+}
+
+func TestPartialUniqueIndex(t *testing.T) {
+	path := "rbac_partial_unique.db"
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("fail to open db %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+		_ = os.Remove(path)
+		_ = os.Remove(path + "-shm")
+		_ = os.Remove(path + "-wal")
+	})
+
+	// the unique index on name also covered soft deleted rows before
+	_, err = db.db.Exec(`CREATE TABLE permission (id INTEGER PRIMARY KEY, name TEXT, description TEXT, created_at DATETIME, updated_at DATETIME, deleted_at INTEGER);
+		CREATE UNIQUE INDEX idx_permission_name ON permission (name asc)`)
+	if err != nil {
+		t.Fatalf("fail to create old permission table: %v", err)
+	}
+
+	permissionStore, err := NewStoreFromDB[models.Permission](db)
+	if err != nil {
+		t.Fatalf("fail to create permissionStore %v", err)
+	}
+	var indexSQL string
+	err = db.db.QueryRow("SELECT sql from sqlite_master where name='idx_permission_name'").Scan(&indexSQL)
+	assert.NoError(t, err)
+	assert.Equal(t, "CREATE UNIQUE INDEX idx_permission_name ON permission (name) WHERE deleted_at = 0", indexSQL)
+
+	id, err := permissionStore.Insert(models.Permission{Name: "read"})
+	assert.NoError(t, err)
+	_, err = permissionStore.Insert(models.Permission{Name: "read"})
+	assert.ErrorIs(t, err, store.ErrDuplicate)
+
+	assert.NoError(t, permissionStore.DeleteMulti([]int64{id}))
+	newID, err := permissionStore.Insert(models.Permission{Name: "read"})
+	assert.NoError(t, err)
+	assert.NotEqual(t, id, newID)
+	// restoring the deleted row would make the name ambiguous
+	assert.ErrorIs(t, permissionStore.Restore([]int64{id}), store.ErrDuplicate)
+
+	ids, err := permissionStore.Upsert([]models.Permission{{Name: "read", Description: "read things"}, {Name: "write"}}, "name")
+	assert.NoError(t, err)
+	assert.Equal(t, newID, ids[0])
+	perm, err := permissionStore.GetOne(newID)
+	assert.NoError(t, err)
+	assert.Equal(t, "read things", perm.Description)
+}
+
+func TestUniqueIndexOverDuplicates(t *testing.T) {
+	path := "rbac_unique_duplicates.db"
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("fail to open db %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+		_ = os.Remove(path)
+		_ = os.Remove(path + "-shm")
+		_ = os.Remove(path + "-wal")
+	})
+
+	// names were not unique before
+	_, err = db.db.Exec(`CREATE TABLE role (id INTEGER PRIMARY KEY, name TEXT, description TEXT, permissions JSON, version INTEGER, created_at DATETIME, updated_at DATETIME, deleted_at INTEGER);
+		INSERT INTO role (name, permissions, version, deleted_at) VALUES ('admin', '[]', 1, 0), ('admin', '[]', 1, 0)`)
+	if err != nil {
+		t.Fatalf("fail to create old role table: %v", err)
+	}
+
+	_, err = NewStoreFromDB[models.Role](db)
+	assert.ErrorIs(t, err, store.ErrDuplicate)
+	assert.ErrorContains(t, err, "role: fail to create unique index idx_role_name, rows share a value of (name)")
+}
+
+func TestTypedColumns(t *testing.T) {
+	path := "rbac_typed.db"
+	typedStore, err := NewStore[Typed](path)
+	if err != nil {
+		t.Fatalf("fail to create typedStore %v", err)
+	}
+	t.Cleanup(func() {
+		_ = typedStore.Close()
+		_ = os.Remove(path)
+		_ = os.Remove(path + "-shm")
+		_ = os.Remove(path + "-wal")
+	})
+
+	types := map[string]sqliteType{}
+	for _, col := range typedStore.columns {
+		types[col.Name] = col.SqLiteType
+	}
+	assert.Equal(t, map[string]sqliteType{
+		"id": sqliteTypeInt, "active": sqliteTypeInt, "avatar": sqliteTypeBlob, "nick": sqliteTypeText, "age": sqliteTypeInt,
+		"seen_at": sqliteTypeDatetime, "note": sqliteTypeText, "score": sqliteTypeReal, "expire_at": sqliteTypeDatetime,
+	}, types)
+
+	nick, age := "bob", int64(42)
+	seenAt := time.Date(2023, 5, 6, 7, 8, 9, 0, time.FixedZone("UTC+8", 8*3600))
+	full := Typed{
+		Active:   true,
+		Avatar:   []byte{0, 1, 2, 0xff},
+		Nick:     &nick,
+		Age:      &age,
+		SeenAt:   &seenAt,
+		Note:     sql.NullString{String: "note", Valid: true},
+		Score:    sql.NullFloat64{Float64: 1.5, Valid: true},
+		ExpireAt: sql.NullTime{Time: seenAt, Valid: true},
+	}
+	fullID, err := typedStore.Insert(full)
+	assert.NoError(t, err)
+	emptyID, err := typedStore.Insert(Typed{})
+	assert.NoError(t, err)
+
+	got, err := typedStore.GetOne(fullID)
+	assert.NoError(t, err)
+	assert.True(t, got.Active)
+	assert.Equal(t, full.Avatar, got.Avatar)
+	assert.Equal(t, nick, *got.Nick)
+	assert.Equal(t, age, *got.Age)
+	assert.True(t, seenAt.Equal(*got.SeenAt))
+	assert.Equal(t, full.Note, got.Note)
+	assert.Equal(t, full.Score, got.Score)
+	assert.True(t, got.ExpireAt.Valid && seenAt.Equal(got.ExpireAt.Time))
+
+	got, err = typedStore.GetOne(emptyID)
+	assert.NoError(t, err)
+	assert.Equal(t, Typed{Id: emptyID}, got)
+
+	var nullCount int
+	err = typedStore.db.QueryRow("SELECT count(*) from typed where nick is null and age is null and seen_at is null and note is null and score is null and expire_at is null").Scan(&nullCount)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, nullCount)
+
+	found, err := typedStore.FindWhere(store.WhereCond{Field: "seen_at", Op: store.OpEqual, Val: seenAt})
+	assert.NoError(t, err)
+	assert.Len(t, found, 1)
+
+	found, err = typedStore.FindFields([]string{"nick", "seen_at"})
+	assert.NoError(t, err)
+	assert.Len(t, found, 2)
+	assert.Equal(t, nick, *found[0].Nick)
+	assert.Nil(t, found[1].Nick)
+	assert.Nil(t, found[1].SeenAt)
+}
+
+func TestAddMissingColumns(t *testing.T) {
+	path := "rbac_add_columns.db"
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("fail to open db %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+		_ = os.Remove(path)
+		_ = os.Remove(path + "-shm")
+		_ = os.Remove(path + "-wal")
+	})
+
+	// the user table before email and last_login_at were added
+	_, err = db.db.Exec(`CREATE TABLE user (id INTEGER PRIMARY KEY, user_id TEXT, roles TEXT, created_at DATETIME, updated_at DATETIME, deleted_at INTEGER);
+		INSERT INTO user (user_id, roles, created_at, updated_at, deleted_at) VALUES ('alice', '[1]', '2023-01-01 00:00:00+00:00', '2023-01-01 00:00:00+00:00', 0)`)
+	if err != nil {
+		t.Fatalf("fail to create old user table: %v", err)
+	}
+
+	userStore, err := NewStoreFromDB[models.User](db)
+	if err != nil {
+		t.Fatalf("fail to create userStore %v", err)
+	}
+	user, err := userStore.GetOne(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", user.UserID)
+	assert.Nil(t, user.Email)
+	assert.Nil(t, user.LastLoginAt)
+
+	email, loginAt := "alice@example.com", time.Now().Truncate(time.Second)
+	user.Email, user.LastLoginAt = &email, &loginAt
+	assert.NoError(t, userStore.Update(user.Id, user))
+	user, err = userStore.GetOne(1)
+	assert.NoError(t, err)
+	assert.Equal(t, email, *user.Email)
+	assert.True(t, loginAt.Equal(*user.LastLoginAt))
+}
+
+func TestBaselineSchema(t *testing.T) {
+	path := "rbac_baseline.db"
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("fail to open db %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+		_ = os.Remove(path)
+		_ = os.Remove(path + "-shm")
+		_ = os.Remove(path + "-wal")
+	})
+
+	// the tables as created before versions, soft delete and timestamps
+	_, err = db.db.Exec(`CREATE TABLE permission (id INTEGER PRIMARY KEY, name TEXT, description TEXT);
+		CREATE TABLE role (id INTEGER PRIMARY KEY, name TEXT, description TEXT, permissions TEXT);
+		CREATE TABLE user (id INTEGER PRIMARY KEY, user_id TEXT, roles TEXT);
+		CREATE UNIQUE INDEX idx_user_id ON user (user_id asc);
+		INSERT INTO permission (name, description) VALUES ('read', 'read things');
+		INSERT INTO role (name, description, permissions) VALUES ('reader', '', '[1]');
+		INSERT INTO user (user_id, roles) VALUES ('alice', '[1]')`)
+	if err != nil {
+		t.Fatalf("fail to create baseline tables: %v", err)
+	}
+
+	permissionStore, err := NewStoreFromDB[models.Permission](db)
+	if err != nil {
+		t.Fatalf("fail to create permissionStore %v", err)
+	}
+	roleStore, err := NewStoreFromDB[models.Role](db)
+	if err != nil {
+		t.Fatalf("fail to create roleStore %v", err)
+	}
+	userStore, err := NewStoreFromDB[models.User](db)
+	if err != nil {
+		t.Fatalf("fail to create userStore %v", err)
+	}
+
+	perms, err := permissionStore.FindWhere()
+	assert.NoError(t, err)
+	if assert.Len(t, perms, 1) {
+		assert.Equal(t, "read", perms[0].Name)
+		assert.True(t, perms[0].CreatedAt.IsZero())
+		assert.Zero(t, perms[0].DeletedAt)
+	}
+
+	role, err := roleStore.GetOne(1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1}, role.Permissions)
+	assert.Equal(t, int64(1), role.Version)
+	role.Description = "reads things"
+	assert.NoError(t, roleStore.Update(role.Id, role))
+	role, err = roleStore.GetOne(1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), role.Version)
+	assert.False(t, role.UpdatedAt.IsZero())
+
+	users, err := userStore.FindWhere(&store.WhereCond{Field: "user_id", Op: store.OpEqual, Val: "alice"})
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+	_, err = userStore.Insert(models.User{UserID: "alice"})
+	assert.ErrorIs(t, err, store.ErrDuplicate)
+
+	assert.NoError(t, permissionStore.DeleteMulti([]int64{1}))
+	deleted, err := permissionStore.FindDeleted()
+	assert.NoError(t, err)
+	assert.Len(t, deleted, 1)
+}
+
+func TestReflectStore(t *testing.T) {
+	path := "rbac_reflect.db"
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("fail to open db %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+		_ = os.Remove(path)
+		_ = os.Remove(path + "-shm")
+		_ = os.Remove(path + "-wal")
+	})
+
+	plainStore, err := NewReflectStoreFromDB[Plain](db)
+	if err != nil {
+		t.Fatalf("fail to create plainStore %v", err)
+	}
+
+	nick := "bob"
+	plain := Plain{
+		Name:    "bob",
+		Tags:    []string{"a", "b"},
+		Attrs:   map[string]string{"k": "v"},
+		Address: &Address{Street: "street", City: "city"},
+		Nick:    &nick,
+	}
+	id, err := plainStore.Insert(plain)
+	assert.NoError(t, err)
+	_, err = plainStore.Insert(Plain{Name: "alice"})
+	assert.NoError(t, err)
+
+	got, err := plainStore.GetOne(id)
+	assert.NoError(t, err)
+	assert.False(t, got.CreatedAt.IsZero())
+	assert.Equal(t, int64(1), got.Version)
+	plain.Id, plain.Version, plain.CreatedAt = id, 1, got.CreatedAt
+	assert.Equal(t, plain, got)
+
+	got.Tags = []string{"c"}
+	assert.NoError(t, plainStore.Update(id, got))
+	err = plainStore.Update(id, got)
+	assert.ErrorIs(t, err, store.ErrConflict)
+
+	found, err := plainStore.FindWhere(store.WhereCond{Field: "name", Op: store.OpEqual, Val: "bob"})
+	assert.NoError(t, err)
+	assert.Len(t, found, 1)
+	assert.Equal(t, []string{"c"}, found[0].Tags)
+
+	ids, err := plainStore.Upsert([]Plain{{Name: "bob", Tags: []string{"d"}}, {Name: "carol"}}, "name")
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{id, 3}, ids)
+
+	assert.NoError(t, plainStore.DeleteMulti([]int64{3}))
+	all, err := plainStore.FindWhere()
+	assert.NoError(t, err)
+	assert.Len(t, all, 2)
+
+	// the reflected encoding reads rows written by hand-written row methods
+	userStore, err := NewStoreFromDB[models.User](db)
+	if err != nil {
+		t.Fatalf("fail to create userStore %v", err)
+	}
+	email := "alice@example.com"
+	userID, err := userStore.Insert(models.User{UserID: "alice", Roles: []int64{1, 2}, Email: &email})
+	assert.NoError(t, err)
+	reflectUserStore, err := NewReflectStoreFromDB[models.User](db)
+	if err != nil {
+		t.Fatalf("fail to create reflectUserStore %v", err)
+	}
+	want, err := userStore.GetOne(userID)
+	assert.NoError(t, err)
+	user, err := reflectUserStore.GetOne(userID)
+	assert.NoError(t, err)
+	assert.Equal(t, want, user)
+
+	user.Roles = []int64{3}
+	assert.NoError(t, reflectUserStore.Update(userID, user))
+	want, err = userStore.GetOne(userID)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{3}, want.Roles)
+
+	_, err = NewReflectStoreFromDB[int](db)
+	assert.Error(t, err)
+}
+
+func TestDuplicate(t *testing.T) {
+	path := "rbac_duplicate.db"
+	userStore, err := NewStore[models.User](path)
+	if err != nil {
+		t.Fatalf("fail to create userStore %v", err)
+	}
+	t.Cleanup(func() {
+		_ = userStore.Close()
+		_ = os.Remove(path)
+		_ = os.Remove(path + "-shm")
+		_ = os.Remove(path + "-wal")
+	})
+
+	_, err = userStore.Insert(models.User{UserID: "alice"})
+	assert.NoError(t, err)
+	bobID, err := userStore.Insert(models.User{UserID: "bob"})
+	assert.NoError(t, err)
+
+	_, err = userStore.Insert(models.User{UserID: "alice"})
+	assert.ErrorIs(t, err, store.ErrDuplicate)
+	assert.ErrorContains(t, err, "UNIQUE constraint failed")
+	err = userStore.UpdateFields(bobID, models.User{UserID: "alice"}, "user_id")
+	assert.ErrorIs(t, err, store.ErrDuplicate)
+	_, err = userStore.InsertMulti([]models.User{{UserID: "carol"}, {UserID: "carol"}})
+	assert.ErrorIs(t, err, store.ErrDuplicate)
+}
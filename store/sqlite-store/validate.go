@@ -0,0 +1,154 @@
+package sqlitestore
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/yinloo-ola/srbac/store"
+)
+
+// validateRow checks that the hand-written FieldsVals and ScanRow of R agree
+// with the columns reflected from the db tags of T. A probe value with a
+// distinct value in every primitive field is passed through FieldsVals, written
+// to a temporary table with the same schema and read back with ScanRow.
+// Any field returned or scanned out of order makes the round trip fail.
+func validateRow[T any, R store.Row[T]](db *sql.DB, tableName string, columns []column) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: row methods panicked on probe value: %v", tableName, r)
+		}
+	}()
+
+	var probe T
+	fillProbe(reflect.ValueOf(&probe).Elem(), columns)
+	probeVal := reflect.ValueOf(probe)
+
+	vals := R(&probe).FieldsVals()
+	if len(vals) != len(columns) {
+		return fmt.Errorf("%s: FieldsVals returned %d values but %d db columns are declared", tableName, len(vals), len(columns))
+	}
+	for _, col := range columns {
+		field := probeVal.Field(col.Index)
+		if col.IsJSON {
+			continue
+		}
+		if !sameValue(vals[col.Index], field) {
+			return fmt.Errorf("%s: FieldsVals()[%d] is %#v but column %q holds %#v; FieldsVals must return fields in struct order",
+				tableName, col.Index, vals[col.Index], col.Name, field.Interface())
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	probeTable := "srbac_probe_" + tableName
+	_, err = tx.Exec(fmt.Sprintf("CREATE TEMP TABLE %s (%s)", probeTable, generateCreateColumnSQL(columns)))
+	if err != nil {
+		return fmt.Errorf("%s: fail to create probe table: %w", tableName, err)
+	}
+
+	columnNames := make([]string, 0, len(columns))
+	placeholders := make([]string, 0, len(columns))
+	for _, col := range columns {
+		columnNames = append(columnNames, col.Name)
+		placeholders = append(placeholders, "?")
+	}
+	_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		probeTable, strings.Join(columnNames, ","), strings.Join(placeholders, ",")), vals...)
+	if err != nil {
+		return fmt.Errorf("%s: fail to insert probe row: %w", tableName, err)
+	}
+
+	var out T
+	row := tx.QueryRow(fmt.Sprintf("SELECT %s from %s", strings.Join(columnNames, ","), probeTable))
+	err = R(&out).ScanRow(row)
+	if err != nil {
+		return fmt.Errorf("%s: ScanRow failed on probe row: %w", tableName, err)
+	}
+
+	outVal := reflect.ValueOf(out)
+	for _, col := range columns {
+		if !equalValue(probeVal.Field(col.Index).Interface(), outVal.Field(col.Index).Interface()) {
+			return fmt.Errorf("%s: column %q wrote %#v but ScanRow read back %#v; ScanRow must scan fields in struct order",
+				tableName, col.Name, probeVal.Field(col.Index).Interface(), outVal.Field(col.Index).Interface())
+		}
+	}
+	return nil
+}
+
+// fillProbe sets every column of v that is not encoded as JSON to a value
+// unique to that column. JSON fields are left zero as they are encoded by the
+// model itself.
+func fillProbe(v reflect.Value, columns []column) {
+	for i, col := range columns {
+		if col.IsJSON {
+			continue
+		}
+		fillValue(v.Field(col.Index), i, col.Name)
+	}
+}
+
+func fillValue(field reflect.Value, i int, name string) {
+	if field.Type() == timeType {
+		field.Set(reflect.ValueOf(time.Date(2000, 1, 1, 0, 0, i, 0, time.UTC)))
+		return
+	}
+	if _, ok := nullTypes[field.Type()]; ok {
+		fillValue(field.Field(0), i, name)
+		field.FieldByName("Valid").SetBool(true)
+		return
+	}
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		field.SetInt(int64(i + 1))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		field.SetUint(uint64(i + 1))
+	case reflect.Float32, reflect.Float64:
+		field.SetFloat(float64(i) + 0.5)
+	case reflect.String:
+		field.SetString("probe_" + name)
+	case reflect.Bool:
+		field.SetBool(true)
+	case reflect.Slice:
+		field.SetBytes([]byte("probe_" + name))
+	case reflect.Pointer:
+		field.Set(reflect.New(field.Type().Elem()))
+		fillValue(field.Elem(), i, name)
+	}
+}
+
+func sameValue(val any, field reflect.Value) bool {
+	v := reflect.ValueOf(val)
+	if !v.IsValid() || !v.Type().ConvertibleTo(field.Type()) {
+		return false
+	}
+	return equalValue(v.Convert(field.Type()).Interface(), field.Interface())
+}
+
+// equalValue is reflect.DeepEqual except that times are equal when they denote
+// the same instant, as the driver reads them back in the local time zone.
+func equalValue(a, b any) bool {
+	switch ta := a.(type) {
+	case time.Time:
+		tb, ok := b.(time.Time)
+		return ok && ta.Equal(tb)
+	case *time.Time:
+		tb, ok := b.(*time.Time)
+		if !ok || ta == nil || tb == nil {
+			return ok && ta == nil && tb == nil
+		}
+		return ta.Equal(*tb)
+	case sql.NullTime:
+		tb, ok := b.(sql.NullTime)
+		return ok && ta.Valid == tb.Valid && ta.Time.Equal(tb.Time)
+	}
+	return reflect.DeepEqual(a, b)
+}
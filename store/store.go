@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 )
 
 type RowScanner interface {
@@ -65,17 +66,102 @@ type Cond interface {
 	GetQueryWithArgs() (string, []any)
 }
 
+// Index describes an index over one or more columns. Columns are db column
+// names, optionally followed by " asc" or " desc". Where turns it into a partial
+// index covering only the rows matching the condition.
+type Index struct {
+	Name    string
+	Columns []string
+	Unique  bool
+	Where   string
+}
+
+// Indexer can be implemented by a model to declare indexes that cannot be
+// expressed with tags, such as partial indexes.
+type Indexer interface {
+	Indexes() []Index
+}
+
 // Store is a generic interface to create, insert, update, retrieve, delete O.
-// Note that O is a struct that might contain an array of primitive values or even structs
+// Note that O is a struct that might contain an array of primitive values or even structs.
 type Store[T any, R Row[T]] interface {
 	Insert(obj T) (int64, error)
+	// InsertMulti inserts objs in a single transaction and returns their ids in the same order.
+	InsertMulti(objs []T) ([]int64, error)
+	// Upsert inserts objs in a single transaction. An obj whose keyField value already
+	// exists updates that row instead. keyField must be a unique indexed column. With a
+	// partial unique index only the rows covered by the index are updated.
+	Upsert(objs []T, keyField string) ([]int64, error)
+	// Update replaces all columns of the row with id. If T has a version column, the update
+	// only succeeds when the stored version equals obj's version and returns ErrConflict otherwise.
 	Update(id int64, obj T) error
+	// UpdateFields updates only the given fields (db column names) of the row with id,
+	// leaving all other columns untouched. Including the version column in fields
+	// guards the update the same way as Update.
+	UpdateFields(id int64, obj T, fields ...string) error
 	GetMulti(ids []int64) ([]T, error)
 	GetOne(id int64) (T, error)
 	// FindWhere WhereConds must be either empty or joined by QueryJoiners
 	FindWhere(...Cond) ([]T, error)
+	// FindFields is like FindWhere but only loads the given fields (db column names)
+	// into the returned objects, leaving all other fields zero.
+	FindFields(fields []string, conds ...Cond) ([]T, error)
+	// Iterate calls fn for each row matching conds one at a time in primary key order.
+	// Returning ErrStopIteration from fn ends the iteration early without error.
+	Iterate(fn func(T) error, conds ...Cond) error
+	// DeleteMulti deletes the rows with ids. Stores supporting soft delete only mark them as deleted.
 	DeleteMulti(ids []int64) error
+	// Restore brings back soft deleted rows with ids.
+	Restore(ids []int64) error
+	// Purge permanently deletes the rows with ids, including soft deleted ones.
+	Purge(ids []int64) error
+	// FindDeleted is like FindWhere but only returns soft deleted rows.
+	FindDeleted(...Cond) ([]T, error)
 	Close() error
 }
 
+// ChangeOp is the kind of write recorded in a ChangeEvent.
+type ChangeOp string
+
+const ChangeInsert ChangeOp = "insert"
+const ChangeUpsert ChangeOp = "upsert"
+const ChangeUpdate ChangeOp = "update"
+const ChangeDelete ChangeOp = "delete"
+const ChangeRestore ChangeOp = "restore"
+
+// ChangeEvent describes a committed write to the rows IDs of Table.
+// Seq increases monotonically across all tables sharing a database.
+type ChangeEvent struct {
+	Seq   int64
+	Table string
+	Op    ChangeOp
+	IDs   []int64
+	At    time.Time
+}
+
+// ChangeFeed is implemented by stores that publish their writes.
+type ChangeFeed interface {
+	// Subscribe returns a channel receiving change events after they are committed and a
+	// function to unsubscribe. A subscriber falling more than buffer events behind has its
+	// channel closed; it should subscribe again and catch up with ChangesSince.
+	Subscribe(buffer int) (<-chan ChangeEvent, func())
+	// ChangesSince returns the persisted change events with a sequence number greater than seq.
+	ChangesSince(seq int64) ([]ChangeEvent, error)
+	// LastSeq returns the sequence number of the latest persisted change event, or 0 if
+	// there is none. It only goes down, to 0, when the latest event is pruned.
+	LastSeq() (int64, error)
+}
+
 var ErrNotFound error = errors.New("record not found")
+
+// ErrStopIteration can be returned by the callback of Store.Iterate to stop early.
+var ErrStopIteration error = errors.New("stop iteration")
+
+// ErrSoftDeleteUnsupported is returned by soft delete operations on types without a soft delete column.
+var ErrSoftDeleteUnsupported error = errors.New("soft delete not supported")
+
+// ErrConflict is returned when a versioned record was modified since it was read.
+var ErrConflict error = errors.New("record version conflict")
+
+// ErrDuplicate is returned when a write would store a value already taken in a unique column.
+var ErrDuplicate error = errors.New("duplicate record")